@@ -0,0 +1,84 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var elfMachines = map[string]elf.Machine{
+	"386":   elf.EM_386,
+	"amd64": elf.EM_X86_64,
+	"arm":   elf.EM_ARM,
+	"arm64": elf.EM_AARCH64,
+}
+
+var machoCpus = map[string]macho.Cpu{
+	"386":   macho.Cpu386,
+	"amd64": macho.CpuAmd64,
+	"arm":   macho.CpuArm,
+	"arm64": macho.CpuArm64,
+}
+
+var peMachines = map[string]uint16{
+	"386":   pe.IMAGE_FILE_MACHINE_I386,
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm":   pe.IMAGE_FILE_MACHINE_ARMNT,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+const rosettaRuntime = "/Library/Apple/usr/libexec/oah/libRosettaRuntime"
+
+// CheckArch confirms the host can execute the binary.
+// Files that are neither ELF, Mach-O nor PE, such as scripts, are not checked.
+func (a *App) CheckArch(p *Package) error {
+	file := p.downloadBinaryFile
+	if f, err := elf.Open(file); err == nil {
+		defer f.Close()
+		if want, ok := elfMachines[runtime.GOARCH]; ok && f.Machine != want {
+			return fmt.Errorf("%s is built for %s, but this host is %s", file, f.Machine, runtime.GOARCH)
+		}
+		return nil
+	}
+	if f, err := pe.Open(file); err == nil {
+		defer f.Close()
+		if want, ok := peMachines[runtime.GOARCH]; ok && f.Machine != want {
+			return fmt.Errorf("%s is built for machine %#x, but this host is %s", file, f.Machine, runtime.GOARCH)
+		}
+		return nil
+	}
+	var cpus []macho.Cpu
+	if f, err := macho.OpenFat(file); err == nil {
+		defer f.Close()
+		for _, arch := range f.Arches {
+			cpus = append(cpus, arch.Cpu)
+		}
+	} else if f, err := macho.Open(file); err == nil {
+		defer f.Close()
+		cpus = append(cpus, f.Cpu)
+	} else {
+		return nil
+	}
+	want, ok := machoCpus[runtime.GOARCH]
+	if !ok {
+		return nil
+	}
+	for _, cpu := range cpus {
+		if cpu == want {
+			return nil
+		}
+	}
+	for _, cpu := range cpus {
+		if cpu == macho.CpuAmd64 && runtime.GOARCH == "arm64" {
+			if _, err := os.Stat(rosettaRuntime); err == nil {
+				a.Log(p, "warning: %s is built for amd64 and will run under Rosetta", file)
+				return nil
+			}
+			return fmt.Errorf("%s is built for amd64, but Rosetta is not installed", file)
+		}
+	}
+	return fmt.Errorf("%s is built for %v, but this host is %s", file, cpus, runtime.GOARCH)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	archiver "github.com/mholt/archiver/v3"
+)
+
+// sniffArchive detects the archive format of file from its content,
+// and returns the canonical extension or "" if it is not a known archive.
+func sniffArchive(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return "", nil
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return ".zip", nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		// only a gzipped tarball has "ustar" at offset 257 once decompressed
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return "", nil
+		}
+		header := make([]byte, 262)
+		if _, err := io.ReadFull(r, header); err == nil && string(header[257:262]) == "ustar" {
+			return ".tar.gz", nil
+		}
+	}
+	return "", nil
+}
+
+// canonicalArchiveExts maps archive extensions to the one sniffArchive reports.
+var canonicalArchiveExts = map[string]string{
+	".tgz":  ".tar.gz",
+	".txz":  ".tar.xz",
+	".tbz2": ".tar.bz2",
+}
+
+func archiveExt(name string) string {
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(name, ext) {
+			if c, ok := canonicalArchiveExts[ext]; ok {
+				return c
+			}
+			return ext
+		}
+	}
+	return ""
+}
+
+// archiveNone as the archive option installs the download without extracting it.
+const archiveNone = "none"
+
+// knownArchiveExts are archive formats go-download recognizes but cannot extract.
+var knownArchiveExts = []string{".tar.zst", ".tar.lz4", ".7z", ".rar"}
+
+// gzipExt is a single gzipped file, not a tarball.
+const gzipExt = ".gz"
+
+func supportedArchiveExt(ext string) bool {
+	if ext == gzipExt {
+		return true
+	}
+	for _, e := range archiveExts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func unsupportedArchiveExt(name string) string {
+	for _, ext := range knownArchiveExts {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+func unsupportedArchiveError(format string) error {
+	return fmt.Errorf("unsupported archive format %s; go-download supports %s. "+
+		"Set archive: to one of them (or none) if the format is misdetected, "+
+		"or file a request at https://github.com/skaji/go-download/issues",
+		format, strings.Join(append(archiveExts, gzipExt), ", "))
+}
+
+var errExtractStopped = errors.New("extraction stopped")
+
+// unarchive extracts file into dir entry by entry, and gives up once stop is closed.
+func unarchive(file, ext, dir string, stop <-chan struct{}) error {
+	iface, err := archiver.ByExtension("archive" + ext)
+	if err != nil {
+		return unsupportedArchiveError(ext)
+	}
+	w, ok := iface.(archiver.Walker)
+	if !ok {
+		return unsupportedArchiveError(ext)
+	}
+	return w.Walk(file, func(f archiver.File) error {
+		select {
+		case <-stop:
+			return errExtractStopped
+		default:
+		}
+		return extractEntry(f, dir, stop)
+	})
+}
+
+func extractEntry(f archiver.File, dir string, stop <-chan struct{}) error {
+	var name, link string
+	hardlink := false
+	switch h := f.Header.(type) {
+	case *tar.Header:
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			// the pax global header of git-generated tarballs
+			return nil
+		}
+		name, link, hardlink = h.Name, h.Linkname, h.Typeflag == tar.TypeLink
+	case zip.FileHeader:
+		name = h.Name
+	default:
+		return fmt.Errorf("unexpected archive header %T", f.Header)
+	}
+	r := &stopReader{r: f, stop: stop}
+	to, err := entryPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if f.IsDir() {
+		return os.MkdirAll(to, 0777)
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+		return err
+	}
+	if hardlink {
+		target, err := entryPath(dir, link)
+		if err != nil {
+			return err
+		}
+		return os.Link(target, to)
+	}
+	if f.Mode()&os.ModeSymlink != 0 {
+		if link == "" {
+			// a symlink in a zip has its target as the content
+			b, err := ioutil.ReadAll(io.LimitReader(r, 4096))
+			if err != nil {
+				return err
+			}
+			link = strings.TrimSpace(string(b))
+		}
+		if err := symlinkTarget(dir, to, link); err != nil {
+			return err
+		}
+		return os.Symlink(link, to)
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, f.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// entryPath joins name in an archive to dir, refusing names that escape dir,
+// either lexically or through a symlink extracted earlier.
+func entryPath(dir, name string) (string, error) {
+	to := filepath.Join(dir, name)
+	if !inDir(dir, to) {
+		return "", fmt.Errorf("%s in the archive points outside of it", name)
+	}
+	for parent := filepath.Dir(to); inDir(dir, parent) && parent != filepath.Clean(dir); parent = filepath.Dir(parent) {
+		if info, err := os.Lstat(parent); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s in the archive is under the symlink %s", name, filepath.Base(parent))
+		}
+	}
+	return to, nil
+}
+
+// symlinkTarget refuses link, the target of the symlink to, if it is absolute or escapes dir.
+func symlinkTarget(dir, to, link string) error {
+	if filepath.IsAbs(link) || strings.HasPrefix(link, "/") || !inDir(dir, filepath.Join(filepath.Dir(to), link)) {
+		return fmt.Errorf("symlink %s in the archive points outside of it, %s", filepath.Base(to), link)
+	}
+	return nil
+}
+
+func inDir(dir, file string) bool {
+	dir = filepath.Clean(dir)
+	return file == dir || strings.HasPrefix(file, dir+string(filepath.Separator))
+}
+
+// stopReader fails reads once stop is closed, to stop extracting a large entry.
+type stopReader struct {
+	r    io.Reader
+	stop <-chan struct{}
+}
+
+func (r *stopReader) Read(b []byte) (int, error) {
+	select {
+	case <-r.stop:
+		return 0, errExtractStopped
+	default:
+	}
+	return r.r.Read(b)
+}
+
+const extractProgressInterval = 5 * time.Second
+
+// unarchive extracts file like the unarchive function, logging progress periodically
+// and giving up after -timeout-extract.
+func (a *App) unarchive(p *Package, file, ext, dir string) error {
+	done := make(chan error, 1)
+	stop := make(chan struct{})
+	go func() { done <- unarchive(file, ext, dir, stop) }()
+	ticker := time.NewTicker(extractProgressInterval)
+	defer ticker.Stop()
+	var timeout <-chan time.Time
+	if a.extractTimeout > 0 {
+		timer := time.NewTimer(a.extractTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	start := time.Now()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			files, size := dirUsage(dir)
+			a.Progress(p, "extracting %s, %d files, %d bytes so far in %s", filepath.Base(file), files, size, time.Since(start).Round(time.Second))
+		case <-timeout:
+			// wait for the extraction to stop, so that nothing is written after the removal
+			close(stop)
+			<-done
+			os.RemoveAll(dir)
+			return fmt.Errorf("extracting %s did not finish in %s", filepath.Base(file), a.extractTimeout)
+		}
+	}
+}
+
+func dirUsage(dir string) (int, int64) {
+	files, size := 0, int64(0)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
+
+// gunzip decompresses file into dir, dropping the .gz suffix from its name.
+func (a *App) gunzip(p *Package, file, dir string) (string, error) {
+	if err := os.Mkdir(dir, 0777); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	defer func() { a.stats.addExtract(time.Since(start)) }()
+	in, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	r, err := gzip.NewReader(in)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", filepath.Base(file), err)
+	}
+	out := filepath.Join(dir, strings.TrimSuffix(filepath.Base(file), gzipExt))
+	w, err := os.Create(out)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	p.extractDir = dir
+	return out, nil
+}
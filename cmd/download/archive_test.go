@@ -0,0 +1,421 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mholt/archiver/v3"
+)
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compressBytes compresses b with c, such as archiver.NewXz().
+func compressBytes(t *testing.T, c archiver.Compressor, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.Compress(bytes.NewReader(b), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBinaryFileArchive(t *testing.T) {
+	a := newTestApp(t)
+	binary := "#!/bin/sh\necho tool\n"
+	gz := gzipBytes(t, []byte(binary))
+	tarball := tarBytes(t, map[string]string{"tool-1.0/tool": binary, "tool-1.0/README": "readme"})
+	tgz := gzipBytes(t, tarball)
+	txz := compressBytes(t, archiver.NewXz(), tarball)
+	tbz2 := compressBytes(t, archiver.NewBz2(), tarball)
+	bigReadme := gzipBytes(t, tarBytes(t, map[string]string{"tool-1.0/tool": binary, "tool-1.0/README.md": strings.Repeat("readme\n", 1000)}))
+	tests := []struct {
+		name     string
+		file     string
+		content  []byte
+		archive  string
+		expect   string
+		extracts bool
+	}{
+		{name: "gz by name", file: "tool.gz", content: gz, expect: "tool", extracts: true},
+		{name: "archive gz without .gz", file: "tool", content: gz, archive: "gz", expect: "tool", extracts: true},
+		{name: "archive .gz", file: "tool-linux", content: gz, archive: ".gz", expect: "tool-linux", extracts: true},
+		{name: "tarball by name", file: "tool.tar.gz", content: tgz, expect: "tool", extracts: true},
+		{name: "tarball by sniffing", file: "tool", content: tgz, expect: "tool", extracts: true},
+		{name: "archive tgz", file: "tool-download", content: tgz, archive: "tgz", expect: "tool", extracts: true},
+		{name: "big README", file: "tool.tar.gz", content: bigReadme, expect: "tool", extracts: true},
+		{name: "tar.xz", file: "tool.tar.xz", content: txz, expect: "tool", extracts: true},
+		{name: "txz", file: "tool.txz", content: txz, expect: "tool", extracts: true},
+		{name: "tar.bz2", file: "tool.tar.bz2", content: tbz2, expect: "tool", extracts: true},
+		{name: "tbz2", file: "tool.tbz2", content: tbz2, expect: "tool", extracts: true},
+		{name: "archive none", file: "tool.tar.gz", content: tgz, archive: archiveNone, expect: "tool.tar.gz"},
+		{name: "plain binary", file: "tool", content: []byte(binary), expect: "tool"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(a.workDir, "case"+string(rune('a'+i)))
+			p := &Package{Name: "tool", Archive: tt.archive}
+			p.downloadFile = writeFile(t, dir, tt.file, string(tt.content))
+			f, err := a.BinaryFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Base(f) != tt.expect {
+				t.Errorf("expect %s, but %s", tt.expect, filepath.Base(f))
+			}
+			if (f != p.downloadFile) != tt.extracts {
+				t.Errorf("expect extracted %v, but %s", tt.extracts, f)
+			}
+			if tt.extracts {
+				got, err := ioutil.ReadFile(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != binary {
+					t.Errorf("expect the binary, but %q", got)
+				}
+			}
+		})
+	}
+}
+
+func zipBytes(t *testing.T, files map[string]string, symlinks map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	for name, target := range symlinks {
+		h := &zip.FileHeader{Name: name}
+		h.SetMode(os.ModeSymlink | 0777)
+		f, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(target))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarEntries(t *testing.T, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, h := range headers {
+		content := h.Linkname
+		if h.Typeflag == tar.TypeReg {
+			content, h.Linkname = h.Linkname, ""
+			h.Size = int64(len(content))
+		} else {
+			content = ""
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnarchive(t *testing.T) {
+	// tarEntries takes the content of a regular file from Linkname
+	tgz := gzipBytes(t, tarEntries(t,
+		&tar.Header{Name: "tool-1.0/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "tool-1.0/bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Linkname: "binary"},
+		&tar.Header{Name: "tool-1.0/tool", Typeflag: tar.TypeSymlink, Linkname: "bin/tool"},
+		&tar.Header{Name: "tool-1.0/tool2", Typeflag: tar.TypeLink, Linkname: "tool-1.0/bin/tool"},
+	))
+	zipped := zipBytes(t, map[string]string{"bin/tool": "binary"}, map[string]string{"tool": "bin/tool"})
+	tests := []struct {
+		name  string
+		ext   string
+		data  []byte
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "tarball",
+			ext:   ".tar.gz",
+			data:  tgz,
+			files: map[string]string{"tool-1.0/bin/tool": "binary", "tool-1.0/tool": "binary", "tool-1.0/tool2": "binary"},
+		},
+		{
+			name:  "zip",
+			ext:   ".zip",
+			data:  zipped,
+			files: map[string]string{"bin/tool": "binary", "tool": "binary"},
+		},
+		{
+			name: "outside of the archive",
+			ext:  ".tar.gz",
+			data: gzipBytes(t, tarEntries(t, &tar.Header{Name: "../tool", Typeflag: tar.TypeReg, Mode: 0755, Linkname: "binary"})),
+			err:  "points outside",
+		},
+		{
+			name: "symlink to an absolute path",
+			ext:  ".tar.gz",
+			data: gzipBytes(t, tarEntries(t, &tar.Header{Name: "tool", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})),
+			err:  "points outside",
+		},
+		{
+			name: "symlink outside of the archive",
+			ext:  ".tar.gz",
+			data: gzipBytes(t, tarEntries(t, &tar.Header{Name: "bin/tool", Typeflag: tar.TypeSymlink, Linkname: "../../tool"})),
+			err:  "points outside",
+		},
+		{
+			name: "zip symlink outside of the archive",
+			ext:  ".zip",
+			data: zipBytes(t, nil, map[string]string{"tool": "../tool"}),
+			err:  "points outside",
+		},
+		{
+			name: "hardlink outside of the archive",
+			ext:  ".tar.gz",
+			data: gzipBytes(t, tarEntries(t, &tar.Header{Name: "tool", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"})),
+			err:  "points outside",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			file := writeFile(t, dir, "archive"+tt.ext, string(tt.data))
+			out := filepath.Join(dir, "out")
+			err := unarchive(file, tt.ext, out, make(chan struct{}))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.files {
+				b, err := ioutil.ReadFile(filepath.Join(out, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != content {
+					t.Errorf("expect %s to be %q, but %q", name, content, b)
+				}
+			}
+		})
+	}
+}
+
+func TestUnarchiveThroughSymlink(t *testing.T) {
+	dir := tempDir(t)
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0777); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0777); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{name: "absolute", headers: []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside},
+			{Name: "a/evil", Typeflag: tar.TypeReg, Mode: 0755, Linkname: "evil"},
+		}},
+		{name: "relative", headers: []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+			{Name: "a/evil", Typeflag: tar.TypeReg, Mode: 0755, Linkname: "evil"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeFile(t, dir, "archive.tar", string(tarEntries(t, tt.headers...)))
+			if err := unarchive(file, ".tar", out, make(chan struct{})); err == nil || !strings.Contains(err.Error(), "points outside") {
+				t.Fatalf("expect the symlink refused, but %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+				t.Errorf("expect nothing written outside, %v", err)
+			}
+		})
+	}
+	// a symlink extracted by an earlier run is not followed either
+	if err := os.Symlink(outside, filepath.Join(out, "b")); err != nil {
+		t.Fatal(err)
+	}
+	file := writeFile(t, dir, "archive.tar", string(tarEntries(t, &tar.Header{Name: "b/evil", Typeflag: tar.TypeReg, Mode: 0755, Linkname: "evil"})))
+	if err := unarchive(file, ".tar", out, make(chan struct{})); err == nil || !strings.Contains(err.Error(), "under the symlink b") {
+		t.Fatalf("expect the entry under the symlink refused, but %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+		t.Errorf("expect nothing written outside, %v", err)
+	}
+}
+
+func TestUnarchiveStop(t *testing.T) {
+	dir := tempDir(t)
+	file := writeFile(t, dir, "archive.tar.gz", string(tgzOf(t, 1<<20)))
+	stop := make(chan struct{})
+	close(stop)
+	out := filepath.Join(dir, "out")
+	if err := unarchive(file, ".tar.gz", out, stop); err == nil || !strings.Contains(err.Error(), errExtractStopped.Error()) {
+		t.Fatalf("expect error %q, but %v", errExtractStopped, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "tool")); err == nil {
+		t.Fatal("expect nothing extracted")
+	}
+}
+
+func TestUnarchiveTimeout(t *testing.T) {
+	a := newTestApp(t, func(opts *options) { opts.extractTimeout = time.Millisecond })
+	dir := tempDir(t)
+	file := writeFile(t, dir, "archive.tar.gz", string(tgzOf(t, 16<<20)))
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0777); err != nil {
+		t.Fatal(err)
+	}
+	err := a.unarchive(&Package{Name: "tool"}, file, ".tar.gz", out)
+	if err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Fatalf("expect a timeout, but %v", err)
+	}
+	// the extraction has stopped, so the removed directory stays removed
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("expect %s to be removed, but %v", out, err)
+	}
+}
+
+// tgzOf returns a tarball of a file named tool of size bytes, all zero.
+func tgzOf(t *testing.T, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	if err := w.WriteHeader(&tar.Header{Name: "tool", Typeflag: tar.TypeReg, Mode: 0755, Size: int64(size)}); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, size))
+	w.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestGzipBinaryRoundTrip(t *testing.T) {
+	a := newTestApp(t)
+	binary := make([]byte, 4096)
+	for i := range binary {
+		binary[i] = byte(i * 7)
+	}
+	p := &Package{Name: "tool"}
+	p.downloadFile = writeFile(t, filepath.Join(a.workDir, "tool"), "tool-linux-amd64.gz", string(gzipBytes(t, binary)))
+	f, err := a.BinaryFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(f) != "tool-linux-amd64" {
+		t.Errorf("expect the .gz suffix dropped, but %s", filepath.Base(f))
+	}
+	p.downloadBinaryFile = f
+	target, err := a.LocateBinaryFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, binary) {
+		t.Error("expect the decompressed binary to be installed as is")
+	}
+	if info, err := os.Stat(target); err != nil || a.os != "windows" && info.Mode()&0111 == 0 {
+		t.Errorf("expect %s to be executable, %v", target, err)
+	}
+}
+
+func TestStripComponents(t *testing.T) {
+	a := newTestApp(t)
+	tgz := gzipBytes(t, tarBytes(t, map[string]string{
+		"tool-1.2.3-linux-amd64/bin/tool":  "#!/bin/sh\necho tool\n",
+		"tool-1.2.3-linux-amd64/tool":      "#!/bin/sh\necho top\n",
+		"tool-1.2.3-linux-amd64/share/big": strings.Repeat("x", 1000),
+	}))
+	tests := []struct {
+		name   string
+		binary string
+		strip  int
+		expect string
+		err    string
+	}{
+		{name: "path without strip", binary: "tool-1.2.3-linux-amd64/bin/tool", expect: "bin/tool"},
+		{name: "path after strip", binary: "bin/tool", strip: 1, expect: "bin/tool"},
+		{name: "name matches at any depth", binary: "tool", strip: 1, err: "multiple files match binary tool"},
+		{name: "glob after strip", binary: "*/tool", strip: 1, expect: "bin/tool"},
+		{name: "unstripped path", binary: "bin/tool", err: "no file matches binary bin/tool"},
+		{name: "strip too deep", binary: "tool", strip: 3, err: "no file matches binary tool"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{Name: "tool", Binary: tt.binary, StripComponents: tt.strip}
+			p.downloadFile = writeFile(t, filepath.Join(a.workDir, "strip"+string(rune('a'+i))), "tool.tar.gz", string(tgz))
+			f, err := a.BinaryFile(p)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(filepath.ToSlash(f), "/tool-1.2.3-linux-amd64/"+tt.expect) {
+				t.Errorf("expect %s, but %s", tt.expect, f)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each cache entry is a top-level directory of cacheDir; its mtime is the
+// last time it was used.
+
+func cacheDir() (string, error) {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserCacheDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "go-download"), nil
+}
+
+// cacheKey names the cache entry of the download of p from u.
+func (a *App) cacheKey(p *Package, u string) string {
+	h := sha256.Sum256([]byte(u + "\n" + p.TargetVersion() + "\n" + p.Checksum.For(a.os, a.arch)))
+	return hex.EncodeToString(h[:16])
+}
+
+// cachedDownload copies the cached download of p from u into the work directory,
+// and reports whether there was a valid one.
+func (a *App) cachedDownload(ctx context.Context, p *Package, u string) (string, bool) {
+	if a.cacheDir == "" {
+		return "", false
+	}
+	dir := filepath.Join(a.cacheDir, a.cacheKey(p, u))
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil || len(infos) != 1 || !infos[0].Mode().IsRegular() {
+		return "", false
+	}
+	file := filepath.Join(a.workDir, p.Name, infos[0].Name())
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return "", false
+	}
+	if err := copyFile(filepath.Join(dir, infos[0].Name()), file); err != nil {
+		a.Log(p, "warning: failed to use the cache, %v", err)
+		return "", false
+	}
+	p.downloadFile = file
+	if err := a.VerifyFile(ctx, p, file); err != nil {
+		a.Log(p, "warning: drop the cached %s, %v", infos[0].Name(), err)
+		os.RemoveAll(dir)
+		os.Remove(file)
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	a.stats.addCacheHit()
+	a.Log(p, "use the cached %s", infos[0].Name())
+	return file, true
+}
+
+// storeCache saves the verified download of p in the cache.
+func (a *App) storeCache(p *Package) error {
+	if a.cacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(a.cacheDir, 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(a.cacheDir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyFile(p.downloadFile, filepath.Join(tmp, filepath.Base(p.downloadFile))); err != nil {
+		return err
+	}
+	dir := filepath.Join(a.cacheDir, a.cacheKey(p, p.downloadURL))
+	os.RemoveAll(dir)
+	return os.Rename(tmp, dir)
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func cacheEntries(dir string) ([]*cacheEntry, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []*cacheEntry
+	for _, info := range infos {
+		e := &cacheEntry{path: filepath.Join(dir, info.Name()), modTime: info.ModTime()}
+		err := filepath.Walk(e.path, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				e.size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// pruneCache removes entries older than maxAge, then the least recently used
+// entries until the total size is at most maxSize. Zero disables each limit.
+func pruneCache(dir string, maxAge time.Duration, maxSize int64) ([]*cacheEntry, error) {
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	total := int64(0)
+	for _, e := range entries {
+		total += e.size
+	}
+	now := time.Now()
+	var removed []*cacheEntry
+	for _, e := range entries {
+		expired := maxAge > 0 && now.Sub(e.modTime) > maxAge
+		tooLarge := maxSize > 0 && total > maxSize
+		if !expired && !tooLarge {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			return removed, err
+		}
+		total -= e.size
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// parseSize parses sizes such as 1024, 500K, 100M or 2G.
+func parseSize(s string) (int64, error) {
+	units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	unit := int64(1)
+	if m, ok := units[strings.ToUpper(s[len(s)-1:])]; ok && len(s) > 1 {
+		unit = m
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// parseAge parses durations accepted by time.ParseDuration, plus days such as 30d.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func pruneCacheCommand(args []string) error {
+	fs := flag.NewFlagSet("prune-cache", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download prune-cache [-max-age AGE] [-max-size SIZE]")
+		fs.PrintDefaults()
+	}
+	maxAgeStr := fs.String("max-age", "", "remove cached assets not used for AGE, e.g. 720h or 30d")
+	maxSizeStr := fs.String("max-size", "", "remove least recently used cached assets until the cache is at most SIZE, e.g. 500M")
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	var maxAge time.Duration
+	var maxSize int64
+	var err error
+	if *maxAgeStr != "" {
+		if maxAge, err = parseAge(*maxAgeStr); err != nil {
+			return err
+		}
+	}
+	if *maxSizeStr != "" {
+		if maxSize, err = parseSize(*maxSizeStr); err != nil {
+			return err
+		}
+	}
+	if maxAge == 0 && maxSize == 0 {
+		return fmt.Errorf("specify -max-age and/or -max-size")
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	removed, err := pruneCache(dir, maxAge, maxSize)
+	freed := int64(0)
+	for _, e := range removed {
+		fmt.Printf("removed %s (%d bytes)\n", e.path, e.size)
+		freed += e.size
+	}
+	fmt.Printf("freed %d bytes in %d entries\n", freed, len(removed))
+	return err
+}
+
+func clearCacheCommand(args []string) error {
+	if len(args) != 0 {
+		fmt.Println("Usage: download clear-cache")
+		return errReported
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		return err
+	}
+	freed := int64(0)
+	for _, e := range entries {
+		if err := os.RemoveAll(e.path); err != nil {
+			return err
+		}
+		freed += e.size
+	}
+	fmt.Printf("freed %d bytes in %d entries\n", freed, len(entries))
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownloadCache(t *testing.T) {
+	const binary = "binary"
+	sum := sha256.Sum256([]byte(binary))
+	checksum := ArchURL{all: "sha256:" + hex.EncodeToString(sum[:])}
+	tests := []struct {
+		name     string
+		noCache  bool
+		checksum bool
+		corrupt  bool
+		requests int
+	}{
+		{name: "cached", requests: 1},
+		{name: "cached with checksum", checksum: true, requests: 1},
+		{name: "no cache", noCache: true, requests: 2},
+		{name: "corrupt cache", checksum: true, corrupt: true, requests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				mu.Unlock()
+				io.WriteString(w, binary)
+			}))
+			defer srv.Close()
+			home := isolateHome(t)
+			newPackage := func() *Package {
+				p := &Package{Name: "tool", downloadURL: srv.URL + "/tool"}
+				p.Version.latest = "v1.0.0"
+				if tt.checksum {
+					p.Checksum = PackageChecksum{Mac: checksum, Linux: checksum, Windows: checksum}
+				}
+				return p
+			}
+			noCache := func(opts *options) { opts.noCache = tt.noCache }
+			for i := 0; i < 2; i++ {
+				a := newTestAppIn(t, home, noCache)
+				p := newPackage()
+				var file string
+				captureStderr(t, func() {
+					var err error
+					if file, err = a.Download(context.Background(), p); err != nil {
+						t.Fatal(err)
+					}
+				})
+				if b, err := ioutil.ReadFile(file); err != nil || string(b) != binary {
+					t.Fatalf("expect the binary, but %q, %v", b, err)
+				}
+				p.downloadFile = file
+				if err := a.storeCache(p); err != nil {
+					t.Fatal(err)
+				}
+				if tt.corrupt && i == 0 {
+					entry := filepath.Join(a.cacheDir, a.cacheKey(p, p.downloadURL), "tool")
+					if err := ioutil.WriteFile(entry, []byte("partial"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if requests != tt.requests {
+				t.Errorf("expect %d requests, but %d", tt.requests, requests)
+			}
+		})
+	}
+}
+
+func TestClearCacheCommand(t *testing.T) {
+	isolateHome(t)
+	dir, err := cacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "entry1/tool", "12345")
+	writeFile(t, dir, "entry2/tool.tar.gz", "1234567890")
+	stdout := captureStdout(t, func() {
+		if err := clearCacheCommand(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stdout != "freed 15 bytes in 2 entries\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+	if entries, err := cacheEntries(dir); err != nil || len(entries) != 0 {
+		t.Errorf("expect the cache empty, but %d entries, %v", len(entries), err)
+	}
+}
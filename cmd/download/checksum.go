@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PackageChecksum is the expected sha256 of the download, either literal
+// "sha256:<hex>" values per OS, or the URL of a sha256sum style checksums file.
+type PackageChecksum struct {
+	Mac     ArchURL `yaml:"mac"`
+	Linux   ArchURL `yaml:"linux"`
+	Windows ArchURL `yaml:"windows"`
+	URL     string  `yaml:"url"`
+}
+
+func (c *PackageChecksum) For(myos, arch string) string {
+	switch myos {
+	case "linux":
+		return c.Linux.For(arch)
+	case "windows":
+		return c.Windows.For(arch)
+	}
+	return c.Mac.For(arch)
+}
+
+func (c *PackageChecksum) build() error {
+	for _, d := range []ArchURL{c.Mac, c.Linux, c.Windows} {
+		for _, v := range d.values() {
+			if _, err := parseSHA256(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseSHA256(s string) (string, error) {
+	hex := strings.TrimPrefix(s, "sha256:")
+	if hex == s || len(hex) != 64 {
+		return "", fmt.Errorf("checksum must be sha256:<64 hex digits>, but %q", s)
+	}
+	return strings.ToLower(hex), nil
+}
+
+// verifyChecksum checks file against checksum.
+func (a *App) verifyChecksum(ctx context.Context, p *Package, file string) error {
+	want, err := a.expectedChecksum(ctx, p, filepath.Base(file))
+	if err != nil || want == "" {
+		return err
+	}
+	got, _, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s, expect %s, but %s", filepath.Base(file), want, got)
+	}
+	a.Log(p, "verified sha256 %s", got)
+	return nil
+}
+
+// expectedChecksum returns the sha256 of the file named name from checksum, or "" if it is not set.
+func (a *App) expectedChecksum(ctx context.Context, p *Package, name string) (string, error) {
+	if s := p.Checksum.For(a.os, a.arch); s != "" {
+		return parseSHA256(s)
+	}
+	if p.Checksum.URL == "" {
+		return "", nil
+	}
+	u := p.expandURL(p.resolveURL(p.Checksum.URL), a.os, a.arch)
+	res, err := a.fetch(ctx, p, u, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return "", fmt.Errorf("expect 2XX response, but %s, %s", res.Status, u)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if want := findChecksum(body, name); want != "" {
+		return want, nil
+	}
+	return "", fmt.Errorf("no checksum of %s in %s", name, u)
+}
+
+// findChecksum finds the hash of name in "<hex>  <filename>" lines.
+func findChecksum(body []byte, name string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		file := strings.TrimPrefix(fields[1], "*")
+		if file == name || path.Base(file) == name {
+			if hex, err := parseSHA256("sha256:" + fields[0]); err == nil {
+				return hex
+			}
+		}
+	}
+	return ""
+}
+
+const checksumFromReleaseBody = "release_body"
+
+// defaultChecksumPattern matches sha256sum output, "<hash>  <file>".
+const defaultChecksumPattern = `([0-9a-fA-F]{64})\s+\*?%f`
+
+// checksumPattern returns the checksum_pattern regexp for the asset named file.
+func (p *Package) checksumPattern(file string) string {
+	pattern := p.ChecksumPattern
+	if pattern == "" {
+		pattern = defaultChecksumPattern
+	}
+	return strings.Replace(pattern, "%f", regexp.QuoteMeta(file), -1)
+}
+
+// verifyReleaseBodyChecksum checks file against the sha256 of the downloaded asset
+// that the maintainer pasted into the release notes.
+func (a *App) verifyReleaseBodyChecksum(ctx context.Context, p *Package, file string) error {
+	release, err := a.Release(ctx, p, p.TargetVersion())
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(p.downloadURL)
+	if err != nil {
+		return err
+	}
+	asset := path.Base(u.Path)
+	reg := regexp.MustCompile(p.checksumPattern(asset))
+	res := reg.FindStringSubmatch(release.Body)
+	if len(res) < 2 {
+		return fmt.Errorf("no checksum of %s in the release notes of %s", asset, release.TagName)
+	}
+	want := strings.ToLower(res[1])
+	got, _, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s, expect %s, but %s", asset, want, got)
+	}
+	a.Log(p, "verified sha256 %s from the release notes", got)
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type PackageCompletion struct {
+	// File is a path, or a glob, relative to the extracted archive
+	File string `yaml:"file"`
+	// Shell is bash, zsh or fish; defaults to the user's shell
+	Shell string `yaml:"shell"`
+}
+
+var shells = map[string]bool{"bash": true, "zsh": true, "fish": true}
+
+// userShell detects the user's shell from $SHELL.
+func userShell() string {
+	if shell := filepath.Base(os.Getenv("SHELL")); shells[shell] {
+		return shell
+	}
+	return "bash"
+}
+
+func dataHome() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+func configHome() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config"), nil
+}
+
+// completionPath returns where the completion of command for shell is installed,
+// following each shell's naming convention.
+func completionPath(shell, command string) (string, error) {
+	switch shell {
+	case "bash":
+		dir, err := dataHome()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "bash-completion", "completions", command), nil
+	case "zsh":
+		dir, err := dataHome()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "zsh", "site-functions", "_"+command), nil
+	case "fish":
+		dir, err := configHome()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "fish", "completions", command+".fish"), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q", shell)
+}
+
+func (a *App) InstallCompletions(p *Package) error {
+	for _, c := range p.Completions {
+		if p.extractDir == "" {
+			return fmt.Errorf("completions require an archive, but %s is not", filepath.Base(p.downloadFile))
+		}
+		matches, err := filepath.Glob(filepath.Join(p.extractDir, c.File))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("completion file %s is not found in the archive", c.File)
+		}
+		shell := c.Shell
+		if shell == "" {
+			shell = userShell()
+		}
+		target, err := completionPath(shell, p.CommandName())
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(matches[0])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+		a.Log(p, "installed %s completion %s", shell, target)
+	}
+	return nil
+}
+
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+}
+
+func completionCommand(args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download completion [-config-dir dir] bash|zsh|fish [packages.yml...]")
+		fs.PrintDefaults()
+	}
+	configDir := fs.String("config-dir", "", configDirUsage)
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errReported
+	}
+	shell, files := fs.Arg(0), fs.Args()[1:]
+	if len(files) == 0 && *configDir == "" {
+		// package names are optional, so a missing default config is fine
+		if file, err := defaultConfigFile(); err == nil {
+			files = []string{file}
+		}
+	}
+	var packages []string
+	if len(files) > 0 || *configDir != "" {
+		config, err := loadConfig(files, *configDir)
+		if err != nil {
+			return err
+		}
+		for _, p := range config.Packages {
+			packages = append(packages, p.Name)
+		}
+	}
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var flags []completionFlag
+	newFlagSet(&options{}).VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, isBool: ok && b.IsBoolFlag()})
+	})
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion(names, flags, packages))
+	case "zsh":
+		fmt.Print(zshCompletion(names, flags, packages))
+	case "fish":
+		fmt.Print(fishCompletion(names, flags, packages))
+	default:
+		return fmt.Errorf("unsupported shell %q", shell)
+	}
+	return nil
+}
+
+func bashCompletion(subcommands []string, flags []completionFlag, packages []string) string {
+	var flagNames []string
+	for _, f := range flags {
+		flagNames = append(flagNames, "-"+f.name)
+	}
+	return fmt.Sprintf(`_download() {
+  local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+  case "$prev" in
+    -only)
+      COMPREPLY=($(compgen -W "%s" -- "$cur"))
+      return
+      ;;
+    completion)
+      COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+      return
+      ;;
+  esac
+  if [[ $cur = -* ]]; then
+    COMPREPLY=($(compgen -W "%s" -- "$cur"))
+  elif [[ $COMP_CWORD -eq 1 ]]; then
+    COMPREPLY=($(compgen -W "%s" -f -- "$cur"))
+  else
+    COMPREPLY=($(compgen -f -- "$cur"))
+  fi
+}
+complete -o filenames -F _download download
+`, strings.Join(packages, " "), strings.Join(flagNames, " "), strings.Join(subcommands, " "))
+}
+
+func zshCompletion(subcommands []string, flags []completionFlag, packages []string) string {
+	var flagSpecs []string
+	for _, f := range flags {
+		usage := strings.NewReplacer("]", "\\]", "'", "'\\''").Replace(f.usage)
+		flagSpecs = append(flagSpecs, fmt.Sprintf("'-%s[%s]'", f.name, usage))
+	}
+	return fmt.Sprintf(`#compdef download
+_download() {
+  local -a subcommands packages
+  subcommands=(%s)
+  packages=(%s)
+  case "${words[CURRENT-1]}" in
+    -only)
+      _values -s , package $packages
+      return
+      ;;
+    completion)
+      compadd bash zsh fish
+      return
+      ;;
+  esac
+  if [[ ${words[CURRENT]} = -* ]]; then
+    _values option %s
+  elif (( CURRENT == 2 )); then
+    compadd -a subcommands
+    _files
+  else
+    _files
+  fi
+}
+if [[ "$funcstack[1]" = "_download" ]]; then
+  _download "$@"
+else
+  compdef _download download
+fi
+`, strings.Join(subcommands, " "), strings.Join(packages, " "), strings.Join(flagSpecs, " "))
+}
+
+func fishCompletion(subcommands []string, flags []completionFlag, packages []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "complete -c download -n __fish_use_subcommand -a '%s'\n", strings.Join(subcommands, " "))
+	fmt.Fprintf(&b, "complete -c download -n '__fish_seen_subcommand_from completion' -x -a 'bash zsh fish'\n")
+	for _, f := range flags {
+		usage := strings.ReplaceAll(f.usage, "'", "\\'")
+		switch {
+		case f.name == "only":
+			fmt.Fprintf(&b, "complete -c download -o %s -x -a '%s' -d '%s'\n", f.name, strings.Join(packages, " "), usage)
+		case f.isBool:
+			fmt.Fprintf(&b, "complete -c download -o %s -d '%s'\n", f.name, usage)
+		default:
+			fmt.Fprintf(&b, "complete -c download -o %s -r -d '%s'\n", f.name, usage)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// versionConstraint is one condition of version.constraint,
+// a comparison such as >=1.2.0 or a glob such as v1.2.*.
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+var constraintOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+// parseConstraint parses space or comma separated conditions, all of which must hold.
+func parseConstraint(s string) ([]versionConstraint, error) {
+	var constraints []versionConstraint
+	for _, field := range strings.Fields(strings.Replace(s, ",", " ", -1)) {
+		c := versionConstraint{version: field}
+		for _, op := range constraintOps {
+			if strings.HasPrefix(field, op) {
+				c.op, c.version = op, strings.TrimPrefix(field, op)
+				break
+			}
+		}
+		if c.version == "" {
+			return nil, fmt.Errorf("invalid version.constraint %q", s)
+		}
+		if c.op == "" {
+			if _, err := path.Match(c.version, ""); err != nil {
+				return nil, fmt.Errorf("invalid version.constraint %q, %w", s, err)
+			}
+		} else if nums, _ := splitVersion(c.version); len(nums) == 0 {
+			return nil, fmt.Errorf("invalid version.constraint %q, %s is not a version", s, c.version)
+		}
+		constraints = append(constraints, c)
+	}
+	if len(constraints) == 0 {
+		return nil, fmt.Errorf("invalid version.constraint %q", s)
+	}
+	return constraints, nil
+}
+
+func (c versionConstraint) holds(v string) bool {
+	if c.op == "" {
+		ok, _ := path.Match(strings.TrimPrefix(c.version, "v"), strings.TrimPrefix(v, "v"))
+		return ok
+	}
+	if nums, _ := splitVersion(v); len(nums) == 0 {
+		return false
+	}
+	cmp := compareVersions(v, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp == 0
+}
+
+func satisfies(v string, constraints []versionConstraint) bool {
+	for _, c := range constraints {
+		if !c.holds(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// prereleaseOption is version.prerelease, false, true or only.
+type prereleaseOption string
+
+const (
+	prereleaseInclude prereleaseOption = "true"
+	prereleaseOnly    prereleaseOption = "only"
+)
+
+func (o *prereleaseOption) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var b bool
+	if err := unmarshal(&b); err == nil {
+		*o = ""
+		if b {
+			*o = prereleaseInclude
+		}
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil || prereleaseOption(s) != prereleaseOnly {
+		return fmt.Errorf("version.prerelease must be true, false or only, but %q", s)
+	}
+	*o = prereleaseOnly
+	return nil
+}
+
+// allows reports whether a release of the prerelease flag is considered.
+func (o prereleaseOption) allows(prerelease bool) bool {
+	switch o {
+	case prereleaseInclude:
+		return true
+	case prereleaseOnly:
+		return prerelease
+	}
+	return !prerelease
+}
+
+// latestVersionFromReleases returns the highest tag among releases that are not drafts,
+// are prereleases or not as version.prerelease allows, and satisfy version.constraint.
+// The other such tags are kept in p.Version.fallbacks for when the highest one is yanked.
+func (a *App) latestVersionFromReleases(ctx context.Context, p *Package) (string, error) {
+	releases, err := a.Releases(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	var versions []string
+	for _, r := range releases {
+		if r.Draft || !p.Version.Prerelease.allows(r.Prerelease) || !satisfies(r.TagName, p.Version.constraints) {
+			continue
+		}
+		versions = append(versions, r.TagName)
+	}
+	if len(versions) == 0 {
+		if p.Version.Constraint != "" {
+			return "", fmt.Errorf("no release satisfies version.constraint %s", p.Version.Constraint)
+		}
+		return "", fmt.Errorf("no release with version.prerelease %s", p.Version.Prerelease)
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) > 0 })
+	p.Version.fallbacks = versions[1:]
+	return versions[0], nil
+}
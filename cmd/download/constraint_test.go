@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// releaseServer serves the releases API of o/r with tags, and the download of
+// each tag in served, 404 for the others.
+func releaseServer(t *testing.T, tags []githubRelease, served ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/repos/o/r/releases" {
+			json.NewEncoder(w).Encode(tags)
+			return
+		}
+		for _, tag := range served {
+			if r.URL.Path == "/o/r/releases/download/"+tag+"/tool" {
+				fmt.Fprintf(w, "#!/bin/sh\necho tool %s\n", tag)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestYankedReleaseFallback(t *testing.T) {
+	tags := []githubRelease{
+		{TagName: "v1.9.0"},
+		{TagName: "v2.0.0"},
+		{TagName: "v2.1.0"},
+		{TagName: "v2.0.1"},
+		{TagName: "v2.2.0-rc1", Prerelease: true},
+		{TagName: "v2.3.0", Draft: true},
+		{TagName: "v3.0.0"},
+	}
+	tests := []struct {
+		name       string
+		constraint string
+		current    string
+		served     []string
+		installed  string
+		err        string
+	}{
+		{name: "newest", constraint: ">=2.0.0 <3", served: []string{"v2.1.0", "v2.0.0"}, installed: "v2.1.0"},
+		{name: "next-highest", constraint: ">=2.0.0 <3", served: []string{"v2.0.0"}, installed: "v2.0.0"},
+		{name: "glob", constraint: "v2.0.*", served: []string{"v2.0.0"}, installed: "v2.0.0"},
+		{name: "fallback is installed", constraint: ">=2.0.0 <3", current: "2.0.1", served: []string{"v2.0.0"}},
+		{name: "all yanked", constraint: ">=2.0.0 <3", served: []string{"v1.9.0"}, err: "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t)
+			srv := releaseServer(t, tags, tt.served...)
+			config := fmt.Sprintf(`
+packages:
+  - name: tool
+    type: script
+    url: %s/o/r
+    download_url:
+      linux: "%%v/tool"
+      mac: "%%v/tool"
+      windows: "%%v/tool"
+    version:
+      constraint: %q
+`, srv.URL, tt.constraint)
+			if tt.current != "" {
+				config += fmt.Sprintf("      command: [sh, -c, echo tool %s]\n      format: 'tool (\\S+)'\n", tt.current)
+			}
+			_, packages := loadTestPackages(t, config)
+			p := packages[0]
+			ctx := context.Background()
+			install, err := a.Resolve(ctx, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !install {
+				t.Fatal("expect to install tool")
+			}
+			err = a.Install(ctx, p)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(a.binDir, "tool"))
+			if tt.installed == "" {
+				if err == nil {
+					t.Fatalf("expect nothing installed, but %q", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), "tool "+tt.installed) {
+				t.Errorf("expect %s installed, but %q", tt.installed, b)
+			}
+		})
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expect     bool
+		err        bool
+	}{
+		{constraint: ">=1.2.0 <2.0.0", version: "v1.2.0", expect: true},
+		{constraint: ">=1.2.0 <2.0.0", version: "1.9.10", expect: true},
+		{constraint: ">=1.2.0 <2.0.0", version: "v2.0.0"},
+		{constraint: ">=1.2.0 <2.0.0", version: "v1.1.9"},
+		{constraint: ">=1.2.0, !=1.3.0", version: "v1.3.0"},
+		{constraint: ">=1.2.0, !=1.3.0", version: "v1.3.1", expect: true},
+		{constraint: ">1.2", version: "v1.2.1", expect: true},
+		{constraint: "<=1.2", version: "v1.2.0", expect: true},
+		{constraint: "=v1.2.0", version: "1.2.0", expect: true},
+		{constraint: "v1.2.*", version: "1.2.7", expect: true},
+		{constraint: "v1.2.*", version: "v1.20.0"},
+		{constraint: ">=1.0.0", version: "nightly"},
+		{constraint: "", err: true},
+		{constraint: ">=", err: true},
+		{constraint: ">=latest", err: true},
+		{constraint: "v1.[", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			constraints, err := parseConstraint(tt.constraint)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expect an error, but %v", constraints)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := satisfies(tt.version, constraints); got != tt.expect {
+				t.Errorf("expect %v, but %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestLatestVersionFromReleases(t *testing.T) {
+	tags := []githubRelease{
+		{TagName: "v1.1.0"},
+		{TagName: "v1.10.0"},
+		{TagName: "v1.2.0"},
+		{TagName: "v1.11.0-rc1", Prerelease: true},
+		{TagName: "v1.12.0", Draft: true},
+		{TagName: "v2.0.0"},
+	}
+	srv := releaseServer(t, tags)
+	tests := []struct {
+		name       string
+		constraint string
+		expect     string
+		fallbacks  []string
+		err        string
+	}{
+		{name: "highest matching", constraint: ">=1.2.0 <2.0.0", expect: "v1.10.0", fallbacks: []string{"v1.2.0"}},
+		{name: "glob", constraint: "v1.1.*", expect: "v1.1.0"},
+		{name: "all", constraint: ">=1", expect: "v2.0.0", fallbacks: []string{"v1.10.0", "v1.2.0", "v1.1.0"}},
+		{name: "none", constraint: ">=3", err: "no release satisfies version.constraint >=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t)
+			_, packages := loadTestPackages(t, fmt.Sprintf(`
+packages:
+  - name: tool
+    url: %s/o/r
+    download_url:
+      linux: "%%v/tool"
+      mac: "%%v/tool"
+      windows: "%%v/tool"
+    version:
+      constraint: %q
+`, srv.URL, tt.constraint))
+			p := packages[0]
+			got, err := a.LatestVersion(context.Background(), p)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expect {
+				t.Errorf("expect %s, but %s", tt.expect, got)
+			}
+			if fmt.Sprint(p.Version.fallbacks) != fmt.Sprint(tt.fallbacks) {
+				t.Errorf("expect fallbacks %v, but %v", tt.fallbacks, p.Version.fallbacks)
+			}
+		})
+	}
+}
+
+func TestPrerelease(t *testing.T) {
+	tags := []githubRelease{
+		{TagName: "v1.0.0"},
+		{TagName: "v1.1.0-rc1", Prerelease: true},
+		{TagName: "v1.1.0-rc2", Prerelease: true},
+		{TagName: "v0.9.0"},
+		{TagName: "v1.2.0-beta1", Prerelease: true, Draft: true},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/o/r/releases":
+			json.NewEncoder(w).Encode(tags)
+		case "/o/r/releases/latest":
+			// the latest release of GitHub is the newest stable one
+			http.Redirect(w, r, "/o/r/releases/tag/v1.0.0", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name       string
+		prerelease string
+		constraint string
+		expect     string
+		err        string
+	}{
+		{name: "absent", expect: "v1.0.0"},
+		{name: "false", prerelease: "false", expect: "v1.0.0"},
+		{name: "true", prerelease: "true", expect: "v1.1.0-rc2"},
+		{name: "only", prerelease: "only", expect: "v1.1.0-rc2"},
+		{name: "only with constraint", prerelease: "only", constraint: "<1.0.1", err: "no release satisfies version.constraint <1.0.1"},
+		{name: "true with constraint", prerelease: "true", constraint: "<1.0.1", expect: "v1.0.0"},
+		{name: "prerelease before its release", prerelease: "true", constraint: "<1.1.0", expect: "v1.1.0-rc2"},
+		{name: "invalid", prerelease: "maybe", err: `version.prerelease must be true, false or only, but "maybe"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t)
+			config := fmt.Sprintf(`
+packages:
+  - name: tool
+    url: %s/o/r
+    download_url:
+      linux: "%%v/tool"
+      mac: "%%v/tool"
+      windows: "%%v/tool"
+    version:
+`, srv.URL)
+			if tt.prerelease != "" {
+				config += "      prerelease: " + tt.prerelease + "\n"
+			}
+			if tt.constraint != "" {
+				config += fmt.Sprintf("      constraint: %q\n", tt.constraint)
+			}
+			file := writeFile(t, tempDir(t), "packages.yml", config)
+			c, err := loadYAML(file)
+			if err == nil {
+				_, err = a.LatestVersion(context.Background(), c.Packages[0])
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := a.LatestVersion(context.Background(), c.Packages[0]); got != tt.expect {
+				t.Errorf("expect %s, but %s", tt.expect, got)
+			}
+		})
+	}
+}
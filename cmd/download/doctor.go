@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type doctor struct {
+	fails int
+}
+
+func (d *doctor) report(result, format string, args ...interface{}) {
+	if result == checkFail {
+		d.fails++
+	}
+	fmt.Printf("[%s] %s\n", result, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) checkBinDir(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		d.report(checkWarn, "%s does not exist, it will be created", dir)
+		return
+	}
+	if !info.IsDir() {
+		d.report(checkFail, "%s is not a directory", dir)
+		return
+	}
+	f, err := ioutil.TempFile(dir, ".doctor")
+	if err != nil {
+		d.report(checkFail, "%s is not writable, %s", dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.report(checkPass, "%s is writable", dir)
+	if inPath(dir) {
+		d.report(checkPass, "%s is in PATH", dir)
+	} else {
+		d.report(checkWarn, "%s is not in PATH", dir)
+	}
+}
+
+func (d *doctor) checkNetwork(client *http.Client) {
+	u := "https://github.com"
+	res, err := client.Head(u)
+	if err != nil {
+		d.report(checkFail, "cannot reach %s, %s", u, err)
+		return
+	}
+	res.Body.Close()
+	d.report(checkPass, "%s is reachable", u)
+}
+
+func (d *doctor) checkTools() {
+	for _, tool := range []struct{ name, usage string }{
+		{"git", "hooks and version commands of some packages"},
+		{"strip", "post_install_strip"},
+		{"curl", "hooks and version commands of some packages"},
+		{"wget", "hooks and version commands of some packages"},
+	} {
+		if path, err := exec.LookPath(tool.name); err == nil {
+			d.report(checkPass, "%s is %s", tool.name, path)
+		} else {
+			d.report(checkWarn, "%s is not found, used by %s", tool.name, tool.usage)
+		}
+	}
+}
+
+func (d *doctor) checkToken(client *http.Client) {
+	for _, env := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+		token := os.Getenv(env)
+		if token == "" {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+		if err != nil {
+			d.report(checkFail, "%s, %s", env, err)
+			return
+		}
+		req.Header.Set("Authorization", "token "+token)
+		res, err := client.Do(req)
+		if err != nil {
+			d.report(checkWarn, "cannot check %s, %s", env, err)
+			return
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			d.report(checkPass, "%s is valid", env)
+		} else {
+			d.report(checkFail, "%s is rejected by GitHub, %s", env, res.Status)
+		}
+		return
+	}
+	d.report(checkWarn, "no GitHub token is configured, API requests are limited to 60 per hour")
+}
+
+func doctorCommand(args []string) error {
+	opts := readOnlyOptions()
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download doctor [-bin-dir dir]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.binDir, "bin-dir", "", "directory binaries are installed to, default $HOME/bin")
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	// give up on the network sooner than downloads do
+	opts.downloadTimeout = 10 * time.Second
+	a, err := NewApp(opts)
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+	d := &doctor{}
+	d.checkBinDir(a.binDir)
+	d.checkNetwork(a.client)
+	d.checkTools()
+	d.checkToken(a.client)
+	if d.fails > 0 {
+		return errReported
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	home := isolateHome(t)
+	binDir := filepath.Join(home, "tools")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "PATH", binDir)
+	a := newTestAppIn(t, home, func(opts *options) { opts.binDir = binDir })
+	d := &doctor{}
+	stdout := captureStdout(t, func() {
+		d.checkBinDir(a.binDir)
+		d.checkToken(a.client)
+	})
+	for _, s := range []string{
+		"[pass] " + binDir + " is writable\n",
+		"[pass] " + binDir + " is in PATH\n",
+		"[warn] no GitHub token is configured",
+	} {
+		if !strings.Contains(stdout, s) {
+			t.Errorf("expect %q in\n%s", s, stdout)
+		}
+	}
+	if d.fails != 0 {
+		t.Errorf("expect no failure, but %d", d.fails)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// expandEnv expands $VAR and ${VAR} in the URL and directory fields of p.
+// Other fields such as version.format are regexps, where $ has its own meaning.
+// Unset variables expand to empty with a warning.
+func (p *Package) expandEnv() {
+	unset := map[string]bool{}
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				unset[name] = true
+			}
+			return v
+		})
+	}
+	for _, s := range []*string{&p.URL, &p.BinDir, &p.TokenURL, &p.Checksum.URL, &p.Signature.URL, &p.Version.LatestURL} {
+		*s = expand(*s)
+	}
+	for _, u := range []*ArchURL{&p.DownloadURL.Mac, &p.DownloadURL.Linux, &p.DownloadURL.Windows} {
+		u.expand(expand)
+	}
+	var names []string
+	for name := range unset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "warning: %s: $%s is not set\n", p.Name, name)
+	}
+}
+
+func (u *ArchURL) expand(f func(string) string) {
+	u.all = f(u.all)
+	for arch, s := range u.byArch {
+		u.byArch[arch] = f(s)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	setenv(t, "GH_HOST", "github.example.com")
+	setenv(t, "TOOLS_DIR", "/opt/tools")
+	setenv(t, "UNSET_TOKEN", "")
+	tests := []struct {
+		name    string
+		field   string
+		expect  string
+		get     func(p *Package) string
+		warning string
+	}{
+		{name: "url with braces", field: "url: https://${GH_HOST}/o/r", expect: "https://github.example.com/o/r", get: func(p *Package) string { return p.URL }},
+		{name: "url without braces", field: "url: https://$GH_HOST/o/r", expect: "https://github.example.com/o/r", get: func(p *Package) string { return p.URL }},
+		{name: "bin_dir", field: "bin_dir: $TOOLS_DIR/bin", expect: "/opt/tools/bin", get: func(p *Package) string { return p.BinDir }},
+		{name: "unset", field: "url: https://github.com/o/r?token=${UNSET_TOKEN}", expect: "https://github.com/o/r?token=", get: func(p *Package) string { return p.URL }, warning: "warning: tool: $UNSET_TOKEN is not set\n"},
+		{name: "format is not expanded", field: "url: https://github.com/o/r", expect: `tool (\S+)$`, get: func(p *Package) string { return p.Version.Format }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := `
+packages:
+  - name: tool
+    ` + tt.field + `
+    download_url:
+      linux: https://${GH_HOST}/o/r/releases/download/%v/tool
+      mac: https://${GH_HOST}/o/r/releases/download/%v/tool
+      windows: {amd64: "https://${GH_HOST}/o/r/releases/download/%v/tool.exe"}
+    version:
+      fixed: v1.0.0
+      command: [tool, --version]
+      format: 'tool (\S+)$'
+`
+			var packages []*Package
+			stderr := captureStderr(t, func() { _, packages = loadTestPackages(t, config) })
+			p := packages[0]
+			if got := tt.get(p); got != tt.expect {
+				t.Errorf("expect %q, but %q", tt.expect, got)
+			}
+			for _, myos := range []string{"linux", "darwin", "windows"} {
+				if u := p.DownloadURL.For(myos, "amd64"); !strings.HasPrefix(u, "https://github.example.com/") {
+					t.Errorf("expect download_url for %s expanded, but %q", myos, u)
+				}
+			}
+			if stderr != tt.warning {
+				t.Errorf("expect warning %q, but %q", tt.warning, stderr)
+			}
+		})
+	}
+}
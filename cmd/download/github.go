@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+type githubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+type githubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Body       string        `json:"body"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	Assets     []githubAsset `json:"assets"`
+}
+
+// githubHosts are the hosts that receive GITHUB_TOKEN; asset downloads redirect
+// to other hosts such as objects.githubusercontent.com, which must not.
+var githubHosts = map[string]bool{
+	"github.com":     true,
+	"api.github.com": true,
+}
+
+func githubToken() string {
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token
+	}
+	return os.Getenv("GH_TOKEN")
+}
+
+// githubTokenTransport adds token to requests to githubHosts.
+type githubTokenTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *githubTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if githubHosts[req.URL.Hostname()] && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// apiBaseURL returns the API endpoint for the host of p.URL;
+// hosts other than github.com are treated as GitHub Enterprise.
+func (p *Package) apiBaseURL() (string, error) {
+	parsed, err := url.Parse(p.URL)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Host == "github.com" {
+		return "https://api.github.com", nil
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/api/v3", nil
+}
+
+// releaseCache holds release API responses for the duration of a run,
+// keyed by the owner/repo/tag API URL, so that packages sharing a release fetch it once.
+type releaseCache struct {
+	mu      sync.Mutex
+	entries map[string]*releaseCacheEntry
+}
+
+type releaseCacheEntry struct {
+	once    sync.Once
+	release *githubRelease
+	err     error
+}
+
+func (c *releaseCache) get(key string, fetch func() (*githubRelease, error)) (*githubRelease, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[string]*releaseCacheEntry{}
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &releaseCacheEntry{}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+	e.once.Do(func() { e.release, e.err = fetch() })
+	return e.release, e.err
+}
+
+func (a *App) githubAPI(ctx context.Context, p *Package, u string, v interface{}) error {
+	a.stats.addAPIRequest()
+	res, err := a.get(ctx, p, u, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return fmt.Errorf("expect 2XX response, but %s, %s", res.Status, u)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (a *App) release(ctx context.Context, p *Package, path string) (*githubRelease, error) {
+	owner, repo, err := p.OwnerRepo()
+	if err != nil {
+		return nil, err
+	}
+	base, err := p.apiBaseURL()
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("%s/repos/%s/%s/releases/%s", base, owner, repo, path)
+	return a.releases.get(u, func() (*githubRelease, error) {
+		var release githubRelease
+		if err := a.githubAPI(ctx, p, u, &release); err != nil {
+			return nil, err
+		}
+		return &release, nil
+	})
+}
+
+// Releases lists the releases of p, following pages of releasesPerPage.
+func (a *App) Releases(ctx context.Context, p *Package) ([]*githubRelease, error) {
+	owner, repo, err := p.OwnerRepo()
+	if err != nil {
+		return nil, err
+	}
+	base, err := p.apiBaseURL()
+	if err != nil {
+		return nil, err
+	}
+	var releases []*githubRelease
+	for page := 1; page <= maxReleasePages; page++ {
+		u := fmt.Sprintf("%s/repos/%s/%s/releases?per_page=%d&page=%d", base, owner, repo, releasesPerPage, page)
+		var list []*githubRelease
+		if err := a.githubAPI(ctx, p, u, &list); err != nil {
+			return nil, err
+		}
+		releases = append(releases, list...)
+		if len(list) < releasesPerPage {
+			break
+		}
+	}
+	return releases, nil
+}
+
+const (
+	releasesPerPage = 100
+	maxReleasePages = 10
+)
+
+func (a *App) Release(ctx context.Context, p *Package, tag string) (*githubRelease, error) {
+	return a.release(ctx, p, "tags/"+url.PathEscape(tag))
+}
+
+func (a *App) LatestRelease(ctx context.Context, p *Package) (*githubRelease, error) {
+	return a.release(ctx, p, "latest")
+}
+
+// SelectAsset picks the release asset matching asset_pattern and not asset_exclude.
+func (a *App) SelectAsset(ctx context.Context, p *Package) (string, error) {
+	pattern := p.assetPatternRegexp(a.os, a.arch)
+	if pattern == nil {
+		return "", fmt.Errorf("asset_pattern is not set for %s", a.os)
+	}
+	release, err := a.Release(ctx, p, p.TargetVersion())
+	if err != nil {
+		return "", err
+	}
+	var candidates []githubAsset
+	for _, asset := range release.Assets {
+		if !pattern.MatchString(asset.Name) {
+			continue
+		}
+		if p.assetExcludeRegexp != nil && p.assetExcludeRegexp.MatchString(asset.Name) {
+			continue
+		}
+		candidates = append(candidates, asset)
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no asset of %s matches asset_pattern %s", release.TagName, pattern)
+	case 1:
+		return candidates[0].BrowserDownloadURL, nil
+	}
+	var names []string
+	for _, asset := range candidates {
+		names = append(names, asset.Name)
+	}
+	return "", fmt.Errorf("multiple assets of %s match asset_pattern %s: %s", release.TagName, pattern, strings.Join(names, ", "))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestGitHubTokenTransport(t *testing.T) {
+	var mu sync.Mutex
+	auth := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth[r.Host+r.URL.Path] = r.Header.Get("Authorization")
+		mu.Unlock()
+		switch r.Host + r.URL.Path {
+		case "github.com/o/r/releases/download/v1/tool":
+			http.Redirect(w, r, "http://objects.githubusercontent.com/blob", http.StatusFound)
+		case "github.com/o/r/releases/download/v1/api":
+			http.Redirect(w, r, "http://api.github.com/repos/o/r", http.StatusFound)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer srv.Close()
+	// every host resolves to srv
+	base := &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}}
+	client := &http.Client{Transport: &githubTokenTransport{base: base, token: "secret"}}
+	tests := []struct {
+		name   string
+		url    string
+		expect map[string]string
+	}{
+		{name: "github.com", url: "http://github.com/o/r", expect: map[string]string{"github.com/o/r": "Bearer secret"}},
+		{name: "api.github.com", url: "http://api.github.com/repos/o/r", expect: map[string]string{"api.github.com/repos/o/r": "Bearer secret"}},
+		{name: "other host", url: "http://example.com/o/r", expect: map[string]string{"example.com/o/r": ""}},
+		{name: "lookalike host", url: "http://github.com.example.com/o/r", expect: map[string]string{"github.com.example.com/o/r": ""}},
+		{
+			name: "redirect to the asset host",
+			url:  "http://github.com/o/r/releases/download/v1/tool",
+			expect: map[string]string{
+				"github.com/o/r/releases/download/v1/tool": "Bearer secret",
+				"objects.githubusercontent.com/blob":       "",
+			},
+		},
+		{
+			name: "redirect between GitHub hosts",
+			url:  "http://github.com/o/r/releases/download/v1/api",
+			expect: map[string]string{
+				"github.com/o/r/releases/download/v1/api": "Bearer secret",
+				"api.github.com/repos/o/r":                "Bearer secret",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			auth = map[string]string{}
+			mu.Unlock()
+			req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			for u, expect := range tt.expect {
+				got, ok := auth[u]
+				if !ok {
+					t.Errorf("expect a request to %s, but %v", u, auth)
+				} else if got != expect {
+					t.Errorf("expect Authorization %q for %s, but %q", expect, u, got)
+				}
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("expect the request of the caller untouched")
+			}
+		})
+	}
+}
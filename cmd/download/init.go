@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var assetOSKeywords = map[string][]string{
+	"linux":  {"linux"},
+	"darwin": {"darwin", "macos", "mac", "osx", "apple"},
+}
+
+var assetArchKeywords = []string{"amd64", "x86_64", "x64"}
+
+var ignoredAssetSuffixes = []string{".sha256", ".sha512", ".sig", ".asc", ".pem", ".txt", ".deb", ".rpm", ".apk", ".json", ".sbom"}
+
+func containsAny(s string, keywords []string) bool {
+	for _, k := range keywords {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// guessAsset picks the asset for myos on amd64, or returns "" if there is no plausible one.
+func guessAsset(assets []githubAsset, myos string) string {
+	var candidates []string
+	for _, asset := range assets {
+		name := strings.ToLower(asset.Name)
+		ignored := false
+		for _, suffix := range ignoredAssetSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				ignored = true
+			}
+		}
+		if !ignored && containsAny(name, assetOSKeywords[myos]) {
+			candidates = append(candidates, asset.Name)
+		}
+	}
+	for _, name := range candidates {
+		if containsAny(strings.ToLower(name), assetArchKeywords) {
+			return name
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return ""
+}
+
+// downloadURLTemplate turns an asset name of tag into a download_url template.
+func downloadURLTemplate(tag, asset string) string {
+	n := strings.TrimPrefix(tag, "v")
+	dir := "%n"
+	if strings.HasPrefix(tag, "v") {
+		dir = "%v"
+	}
+	name := replaceWord(asset, tag, dir)
+	if n != "" {
+		name = replaceWord(name, n, "%n")
+	}
+	return dir + "/" + name
+}
+
+// replaceWord replaces old in s with repl where old is not part of a longer word,
+// so that the version 6 does not change amd64 for example.
+func replaceWord(s, old, repl string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i+len(old) <= len(s); {
+		end := i + len(old)
+		if s[i:end] == old && (i == 0 || !isAlnum(s[i-1])) && (end == len(s) || !isAlnum(s[end])) {
+			b.WriteString(s[last:i] + repl)
+			last, i = end, end
+			continue
+		}
+		i++
+	}
+	return b.String() + s[last:]
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func normalizeGitHubURL(s string) string {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), ".git")
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	return s
+}
+
+// scaffoldPackage inspects the latest release of repoURL and returns a packages.yml entry for it.
+func (a *App) scaffoldPackage(ctx context.Context, repoURL, versionCommand string) (string, error) {
+	p := &Package{URL: normalizeGitHubURL(repoURL)}
+	_, repo, err := p.OwnerRepo()
+	if err != nil {
+		return "", err
+	}
+	p.Name = repo
+	release, err := a.LatestRelease(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	if versionCommand == "" {
+		versionCommand = repo + " --version"
+	}
+	var command []string
+	for _, arg := range strings.Fields(versionCommand) {
+		command = append(command, fmt.Sprintf("%q", arg))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  - name: %s\n", p.Name)
+	fmt.Fprintf(&b, "    url: %s\n", p.URL)
+	fmt.Fprintf(&b, "    download_url:\n")
+	for _, target := range []struct{ key, goos string }{{"mac", "darwin"}, {"linux", "linux"}} {
+		if asset := guessAsset(release.Assets, target.goos); asset != "" {
+			fmt.Fprintf(&b, "      %s: %q\n", target.key, downloadURLTemplate(release.TagName, asset))
+		} else {
+			fmt.Fprintf(&b, "      %s: \"TODO\" # no %s asset detected in %s\n", target.key, target.goos, release.TagName)
+		}
+	}
+	fmt.Fprintf(&b, "    version:\n")
+	fmt.Fprintf(&b, "      command: [%s]\n", strings.Join(command, ", "))
+	fmt.Fprintf(&b, "      format: '%s ([\\d.]+)' # TODO: check the output of the version command\n", repo)
+	return b.String(), nil
+}
+
+// examplePackages is written by init without arguments.
+const examplePackages = `# packages.yml for go-download, see https://github.com/skaji/go-download
+packages:
+  # name is the name of the installed binary
+  - name: gh
+    # url is the GitHub repository, whose latest release is installed
+    url: https://github.com/cli/cli
+    # download_url is relative to url/releases/download/, or a full URL.
+    # %v is the tag of the release such as v2.40.0, %n is it without v,
+    # and %arch is amd64 or arm64
+    download_url:
+      mac: "%v/gh_%n_macOS_%arch.zip"
+      linux: "%v/gh_%n_linux_%arch.tar.gz"
+    version:
+      # command prints the installed version, and format captures it
+      command: [gh, --version]
+      format: 'gh version ([\d.]+)'
+      # fixed pins the version instead of following the latest release
+      # fixed: v2.40.0
+`
+
+func initCommand(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download init [-force]")
+		fmt.Println("       download init github-url")
+		fs.PrintDefaults()
+	}
+	force := fs.Bool("force", false, "overwrite an existing packages.yml")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return errReported
+	}
+	switch len(args) {
+	case 0:
+		return writeExamplePackages("packages.yml", *force)
+	case 1:
+	default:
+		fs.Usage()
+		return errReported
+	}
+	a, err := NewApp(readOnlyOptions())
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+	entry, err := a.scaffoldPackage(a.ctx, args[0], "")
+	if err != nil {
+		return err
+	}
+	fmt.Print("packages:\n" + entry)
+	return nil
+}
+
+func writeExamplePackages(file string, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(file, flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", file)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(examplePackages); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", file)
+	return nil
+}
+
+func addCommand(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download add [-version-command command] github-url packages.yml")
+		fs.PrintDefaults()
+	}
+	versionCommand := fs.String("version-command", "", "command that prints the installed version, default \"repo --version\"")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return errReported
+	}
+	if len(args) != 2 {
+		fs.Usage()
+		return errReported
+	}
+	repoURL, file := args[0], args[1]
+	config, err := loadYAML(file)
+	if err != nil {
+		return err
+	}
+	a, err := NewApp(readOnlyOptions())
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+	entry, err := a.scaffoldPackage(a.ctx, repoURL, *versionCommand)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(strings.SplitN(entry, "\n", 2)[0], "  - name: ")
+	if _, err := findPackage(config.Packages, name); err == nil {
+		return fmt.Errorf("%s already has a package named %s", file, name)
+	}
+	c, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(appendPackage(string(c), entry)), 0644); err != nil {
+		return err
+	}
+	added, err := loadYAML(tmp)
+	if err == nil {
+		_, err = findPackage(added.Packages, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to add %s to %s, %w", name, file, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "added %s to %s, check the TODOs in it\n", name, file)
+	return nil
+}
+
+// appendPackage inserts entry at the end of the packages list of config, which may be
+// followed by other top-level keys, keeping the rest of config as is.
+func appendPackage(config, entry string) string {
+	lines := strings.SplitAfter(config, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "packages:") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		if config != "" && !strings.HasSuffix(config, "\n") {
+			config += "\n"
+		}
+		return config + "packages:\n" + entry
+	}
+	end, indent := len(lines), "  "
+	found := false
+	for i := start + 1; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		if !found && strings.HasPrefix(trimmed, "- ") {
+			indent, found = line[:len(line)-len(trimmed)], true
+		}
+		if line != "" && line == trimmed && !strings.HasPrefix(line, "\n") && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "- ") {
+			end = i
+			break
+		}
+	}
+	// the entry goes before blank lines and comments that precede the next key
+	for end > start+1 && (strings.TrimSpace(lines[end-1]) == "" || strings.HasPrefix(lines[end-1], "#")) {
+		end--
+	}
+	if indent != "  " {
+		// scaffoldPackage indents items by two spaces
+		var b strings.Builder
+		for _, line := range strings.SplitAfter(entry, "\n") {
+			if line != "" {
+				b.WriteString(indent + strings.TrimPrefix(line, "  "))
+			}
+		}
+		entry = b.String()
+	}
+	head := strings.Join(lines[:end], "")
+	if !strings.HasSuffix(head, "\n") {
+		head += "\n"
+	}
+	return head + entry + strings.Join(lines[end:], "")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInitCommand(t *testing.T) {
+	isolateHome(t)
+	dir := tempDir(t)
+	chdir(t, dir)
+	captureStderr(t, func() {
+		if err := initCommand(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	c, err := loadYAML("packages.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Packages) != 1 {
+		t.Fatalf("expect an example package, but %d", len(c.Packages))
+	}
+	p := c.Packages[0]
+	if p.Name != "gh" || p.URL != "https://github.com/cli/cli" {
+		t.Errorf("unexpected package %s %s", p.Name, p.URL)
+	}
+	for _, myos := range []string{"darwin", "linux"} {
+		if p.DownloadURL.For(myos, "arm64") == "" {
+			t.Errorf("expect download_url for %s", myos)
+		}
+	}
+	if len(p.Version.Command) == 0 || p.Version.formatRegexp == nil {
+		t.Error("expect version.command and version.format")
+	}
+	if !strings.Contains(examplePackages, "# fixed: ") {
+		t.Error("expect version.fixed documented")
+	}
+
+	writeFile(t, dir, "packages.yml", "packages: []\n")
+	if err := initCommand(nil); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expect to refuse to overwrite, but %v", err)
+	}
+	if b, _ := ioutil.ReadFile("packages.yml"); string(b) != "packages: []\n" {
+		t.Errorf("expect packages.yml kept, but %q", b)
+	}
+	captureStderr(t, func() {
+		if err := initCommand([]string{"-force"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if b, _ := ioutil.ReadFile("packages.yml"); string(b) != examplePackages {
+		t.Errorf("expect packages.yml overwritten with -force, but %q", b)
+	}
+}
+
+func TestAddCommand(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/o/mytool/releases/latest", "/api/v3/repos/o/other/releases/latest":
+			json.NewEncoder(w).Encode(githubRelease{TagName: "v1.4.0", Assets: []githubAsset{
+				{Name: "mytool_1.4.0_checksums.txt"},
+				{Name: "mytool_1.4.0_linux_arm64.tar.gz"},
+				{Name: "mytool_1.4.0_linux_amd64.tar.gz"},
+				{Name: "mytool_1.4.0_darwin_all.tar.gz"},
+			}})
+		case "/api/v3/repos/o/linuxonly/releases/latest":
+			json.NewEncoder(w).Encode(githubRelease{TagName: "2.0", Assets: []githubAsset{
+				{Name: "linuxonly-2.0-linux-x86_64"},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	const existing = `packages:
+  - name: other
+    url: https://github.com/o/other
+    download_url:
+      linux: "%v/other"
+    version:
+      fixed: v1.0.0
+after_all: [true]
+`
+	tests := []struct {
+		name   string
+		args   []string
+		expect []string
+		err    string
+	}{
+		{name: "detected", args: []string{srv.URL + "/o/mytool"}, expect: []string{
+			"  - name: mytool\n",
+			"    url: " + srv.URL + "/o/mytool\n",
+			`      mac: "%v/mytool_%n_darwin_all.tar.gz"`,
+			`      linux: "%v/mytool_%n_linux_amd64.tar.gz"`,
+			`      command: ["mytool", "--version"]`,
+		}},
+		{name: "version command", args: []string{"-version-command", "mytool version", srv.URL + "/o/mytool"}, expect: []string{
+			`      command: ["mytool", "version"]`,
+		}},
+		{name: "TODO", args: []string{srv.URL + "/o/linuxonly.git"}, expect: []string{
+			"    url: " + srv.URL + "/o/linuxonly\n",
+			`      mac: "TODO" # no darwin asset detected in 2.0`,
+			`      linux: "%n/linuxonly-%n-linux-x86_64"`,
+		}},
+		{name: "duplicate", args: []string{srv.URL + "/o/other"}, err: "already has a package named other"},
+		{name: "no release", args: []string{srv.URL + "/o/missing"}, err: "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateHome(t)
+			file := writeFile(t, tempDir(t), "packages.yml", existing)
+			var err error
+			captureStderr(t, func() { err = addCommand(append(tt.args, file)) })
+			b, _ := ioutil.ReadFile(file)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				if string(b) != existing {
+					t.Errorf("expect %s unchanged, but %q", file, b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.expect {
+				if !strings.Contains(string(b), s) {
+					t.Errorf("expect %q in\n%s", s, b)
+				}
+			}
+			c, err := loadYAML(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Packages) != 2 || c.Packages[0].Name != "other" || len(c.AfterAll) != 1 {
+				t.Errorf("expect the other entries kept, but\n%s", b)
+			}
+		})
+	}
+}
+
+func TestDownloadURLTemplate(t *testing.T) {
+	tests := []struct {
+		tag    string
+		asset  string
+		expect string
+	}{
+		{tag: "v1.4.0", asset: "mytool_1.4.0_linux_amd64.tar.gz", expect: "%v/mytool_%n_linux_amd64.tar.gz"},
+		{tag: "v1.4.0", asset: "mytool-v1.4.0-linux-amd64", expect: "%v/mytool-%v-linux-amd64"},
+		{tag: "2.0", asset: "tool-2.0-linux-x86_64", expect: "%n/tool-%n-linux-x86_64"},
+		{tag: "v6", asset: "tool_linux_amd64", expect: "%v/tool_linux_amd64"},
+		{tag: "v6", asset: "tool_6_linux_amd64.tar.gz", expect: "%v/tool_%n_linux_amd64.tar.gz"},
+		{tag: "v6", asset: "tool66_6", expect: "%v/tool66_%n"},
+		{tag: "8", asset: "tool-8-linux-arm64", expect: "%n/tool-%n-linux-arm64"},
+	}
+	for _, tt := range tests {
+		if got := downloadURLTemplate(tt.tag, tt.asset); got != tt.expect {
+			t.Errorf("expect %s for %s of %s, but %s", tt.expect, tt.asset, tt.tag, got)
+		}
+	}
+}
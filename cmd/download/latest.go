@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	sourceGitHub = "github"
+	sourceGitLab = "gitlab"
+	sourceJSON   = "json"
+	sourceHTML   = "html"
+)
+
+func (v *PackageVersion) buildSource() error {
+	switch v.Source {
+	case "", sourceGitHub, sourceGitLab:
+		return nil
+	case sourceJSON:
+		if v.LatestJSONPath == "" {
+			return errors.New("version.latest_json_path is required with version.source json")
+		}
+	case sourceHTML:
+		if v.LatestPattern == "" {
+			return errors.New("version.latest_pattern is required with version.source html")
+		}
+		reg, err := regexp.Compile(v.LatestPattern)
+		if err != nil {
+			return fmt.Errorf("invalid version.latest_pattern, %w", err)
+		}
+		v.latestRegexp = reg
+	default:
+		return fmt.Errorf("version.source must be github, gitlab, json or html, but %q", v.Source)
+	}
+	if v.LatestURL == "" {
+		return fmt.Errorf("version.latest_url is required with version.source %s", v.Source)
+	}
+	if len(v.LatestCommand) > 0 {
+		return fmt.Errorf("version.latest_command cannot be used with version.source %s", v.Source)
+	}
+	return nil
+}
+
+// latestVersionFromRedirect returns the last path segment of the redirect of u,
+// such as github.com/owner/repo/releases/tag/v1.2.3 for github.com/owner/repo/releases/latest.
+func (a *App) latestVersionFromRedirect(ctx context.Context, p *Package, u string) (string, error) {
+	a.stats.addAPIRequest()
+	res, err := a.do(ctx, p, a.noRedirectClient, u, nil)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode/100 != 3 {
+		return "", fmt.Errorf("expect 3XX response, but %s, %s", res.Status, u)
+	}
+	parts := strings.Split(strings.TrimSuffix(res.Header.Get("Location"), "/"), "/")
+	if v := parts[len(parts)-1]; v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("response does not contain Location Header")
+}
+
+// latestVersionFromURL extracts the latest version from the body of version.latest_url.
+func (a *App) latestVersionFromURL(ctx context.Context, p *Package) (string, error) {
+	u := p.Version.LatestURL
+	a.stats.addAPIRequest()
+	body, err := a.fetchBytes(ctx, p, u)
+	if err != nil {
+		return "", err
+	}
+	if p.Version.Source == sourceJSON {
+		v, err := jsonPathValue(body, p.Version.LatestJSONPath)
+		if err != nil {
+			return "", fmt.Errorf("cannot determine latest version from %s, %w", u, err)
+		}
+		return v, nil
+	}
+	latest := &PackageVersion{formatRegexp: p.Version.latestRegexp, Match: p.Version.Match}
+	v, err := latest.parse(body, body)
+	if err != nil {
+		return "", fmt.Errorf("cannot determine latest version from %s, %w", u, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLatestVersionSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		handler http.HandlerFunc
+		expect  string
+		err     string
+	}{
+		{name: "gitlab", version: "{source: gitlab}", handler: func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/o/tool/-/releases/permalink/latest" {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, "/o/tool/-/releases/v1.2.0", http.StatusFound)
+		}, expect: "v1.2.0"},
+		{name: "gitlab without redirect", version: "{source: gitlab}", handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "<html></html>")
+		}, err: "expect 3XX response"},
+		{name: "gitlab without Location", version: "{source: gitlab}", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusFound)
+		}, err: "does not contain Location"},
+		{name: "json", version: "{source: json, latest_url: '%s/latest.json', latest_json_path: release.tag}", handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `{"release": {"tag": "v2.0.1"}}`)
+		}, expect: "v2.0.1"},
+		{name: "json malformed", version: "{source: json, latest_url: '%s/latest.json', latest_json_path: release.tag}", handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `{"release": `)
+		}, err: "invalid JSON"},
+		{name: "json missing field", version: "{source: json, latest_url: '%s/latest.json', latest_json_path: release.tag}", handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `{"release": {"name": "v2.0.1"}}`)
+		}, err: "json_path release.tag does not exist"},
+		{name: "json not found", version: "{source: json, latest_url: '%s/latest.json', latest_json_path: release.tag}", handler: http.NotFound, err: "404"},
+		{name: "html", version: `{source: html, latest_url: '%s/download', latest_pattern: 'tool-(\d+\.\d+\.\d+)\.tar\.gz'}`, handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `<a href="tool-3.1.0.tar.gz">tool-3.1.0.tar.gz</a>`)
+		}, expect: "3.1.0"},
+		{name: "html malformed", version: `{source: html, latest_url: '%s/download', latest_pattern: 'tool-(\d+\.\d+\.\d+)\.tar\.gz'}`, handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, `<a href="tool-3.1.tar.gz`)
+		}, err: "cannot determine latest version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			version := tt.version
+			if strings.Contains(version, "%s") {
+				version = fmt.Sprintf(version, srv.URL)
+			}
+			_, packages := loadTestPackages(t, fmt.Sprintf(`
+packages:
+  - name: tool
+    url: %s/o/tool
+    download_url: {linux: "%%v/tool", mac: "%%v/tool", windows: "%%v/tool.exe"}
+    version: %s
+`, srv.URL, version))
+			a := newTestApp(t)
+			var v string
+			var err error
+			captureStderr(t, func() { v, err = a.LatestVersion(a.ctx, packages[0]) })
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %q, %v", tt.err, v, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != tt.expect {
+				t.Errorf("expect %s, but %s", tt.expect, v)
+			}
+		})
+	}
+}
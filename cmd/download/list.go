@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"text/tabwriter"
+)
+
+const (
+	listUpToDate     = "up to date"
+	listOutdated     = "outdated"
+	listNotInstalled = "not installed"
+	listUnknown      = "-"
+)
+
+type listEntry struct {
+	current, latest, status string
+}
+
+// List looks up the current and the latest versions of p without installing it.
+func (a *App) List(ctx context.Context, p *Package) listEntry {
+	e := listEntry{current: listUnknown, latest: listUnknown, status: listUnknown}
+	if !p.SupportsOS(a.os, a.arch) {
+		e.status = "unsupported"
+		return e
+	}
+	current, err := a.CurrentVersion(ctx, p)
+	if err == nil {
+		e.current = current
+	} else if errors.Is(err, exec.ErrNotFound) {
+		e.current = listNotInstalled
+	} else if err != errSkip {
+		a.Log(p, "failed to get the current version, %v", err)
+	}
+	if p.Version.Fixed != "" {
+		e.latest = p.Version.Fixed
+	} else if latest, err := a.LatestVersion(ctx, p); err == nil {
+		e.latest = latest
+	} else {
+		a.Log(p, "failed to get the latest version, %v", err)
+	}
+	switch {
+	case e.current == listNotInstalled:
+		e.status = listOutdated
+	case e.current == listUnknown || e.latest == listUnknown:
+	default:
+		p.Version.current, p.Version.latest = e.current, e.latest
+		if p.AlreadyLatestVersion() {
+			e.status = listUpToDate
+		} else {
+			e.status = listOutdated
+		}
+	}
+	return e
+}
+
+func listCommand(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download list [-exit-code] [-config-dir dir] [packages.yml...]")
+		fs.PrintDefaults()
+	}
+	exitCode := fs.Bool("exit-code", false, "exit with 1 if any package is outdated")
+	configDir := fs.String("config-dir", "", configDirUsage)
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	config, err := loadConfigOrDefault(fs.Args(), *configDir)
+	if err != nil {
+		return err
+	}
+	a, err := NewApp(readOnlyOptions())
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+
+	var mu sync.Mutex
+	entries := make(map[*Package]listEntry, len(config.Packages))
+	a.parallel(config.Packages, resolveJobs, func(p *Package) error {
+		e := a.List(a.ctx, p)
+		mu.Lock()
+		entries[p] = e
+		mu.Unlock()
+		return nil
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCURRENT\tLATEST\tSTATUS")
+	outdated := false
+	for _, p := range config.Packages {
+		e, ok := entries[p]
+		if !ok {
+			e = listEntry{current: listUnknown, latest: listUnknown, status: listUnknown}
+		}
+		if e.status == listOutdated {
+			outdated = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, e.current, e.latest, e.status)
+	}
+	w.Flush()
+	if *exitCode && outdated {
+		return errReported
+	}
+	return nil
+}
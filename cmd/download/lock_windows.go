@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// flock is a no-op on windows; concurrent runs are not prevented.
+func flock(f *os.File, wait bool) error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const lockfileName = "packages.lock"
+
+// Lockfile records the installed version of each package, and the download URL and sha256
+// per os/arch, so that -locked installs the same on other machines.
+type Lockfile struct {
+	Packages map[string]*LockedPackage `json:"packages"`
+
+	mu      sync.Mutex
+	file    string
+	changed bool
+}
+
+type LockedPackage struct {
+	Version string                  `json:"version"`
+	Assets  map[string]*LockedAsset `json:"assets,omitempty"`
+}
+
+type LockedAsset struct {
+	URL    string `json:"url,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// lockfilePath returns packages.lock next to the first config file,
+// or in the config dir if there is no file.
+func lockfilePath(files []string, dir string) string {
+	if len(files) > 0 {
+		return filepath.Join(filepath.Dir(files[0]), lockfileName)
+	}
+	return filepath.Join(dir, lockfileName)
+}
+
+func loadLockfile(file string) (*Lockfile, error) {
+	l := &Lockfile{file: file}
+	c, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(c, l); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if l.Packages == nil {
+		l.Packages = map[string]*LockedPackage{}
+	}
+	return l, nil
+}
+
+func (l *Lockfile) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.changed {
+		return nil
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(l.file, append(b, '\n'), 0644)
+}
+
+// record updates the entry of the package just installed, and drops the assets
+// of other platforms if the version has changed.
+func (l *Lockfile) record(p *Package, myos, arch string) error {
+	sum, _, err := hashFile(p.downloadFile)
+	if err != nil {
+		return err
+	}
+	asset := &LockedAsset{SHA256: sum}
+	if p.TokenURL == "" {
+		// a URL with %token is only valid once
+		asset.URL = p.downloadURL
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.entry(p)
+	if e.Assets == nil {
+		e.Assets = map[string]*LockedAsset{}
+	}
+	e.Assets[myos+"/"+arch] = asset
+	l.changed = true
+	return nil
+}
+
+// recordVersion updates the entry of a package that is already up to date,
+// without assets unless the version is unchanged.
+func (l *Lockfile) recordVersion(p *Package) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entry(p)
+}
+
+// entry returns the entry of p at its target version, replacing one of another version.
+func (l *Lockfile) entry(p *Package) *LockedPackage {
+	version := p.TargetVersion()
+	e := l.Packages[p.Name]
+	if e == nil || e.Version != version {
+		e = &LockedPackage{Version: version}
+		l.Packages[p.Name] = e
+		l.changed = true
+	}
+	return e
+}
+
+// lock pins packages to the versions in l, and fails if l has no entry for some of them
+// or disagrees with their version.fixed.
+func (l *Lockfile) lock(packages []*Package) error {
+	var missing []string
+	for _, p := range packages {
+		e := l.Packages[p.Name]
+		if e == nil {
+			missing = append(missing, p.Name)
+			continue
+		}
+		if p.Version.Fixed != "" && strings.TrimPrefix(p.Version.Fixed, "v") != strings.TrimPrefix(e.Version, "v") {
+			return fmt.Errorf("%s: version.fixed is %s, but %s has %s", p.Name, p.Version.Fixed, l.file, e.Version)
+		}
+		p.locked = e
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s has no entry for %s, run without -locked to update it", l.file, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (p *Package) lockedAsset(myos, arch string) *LockedAsset {
+	if p.locked == nil {
+		return nil
+	}
+	return p.locked.Assets[myos+"/"+arch]
+}
+
+// verifyLocked checks the downloaded file against the sha256 in the lockfile,
+// and removes it on mismatch.
+func (a *App) verifyLocked(p *Package) error {
+	asset := p.lockedAsset(a.os, a.arch)
+	if asset == nil || asset.SHA256 == "" {
+		return nil
+	}
+	got, _, err := hashFile(p.downloadFile)
+	if err != nil {
+		return err
+	}
+	if got != asset.SHA256 {
+		os.Remove(p.downloadFile)
+		return fmt.Errorf("checksum mismatch for %s, %s has %s, but %s", filepath.Base(p.downloadFile), lockfileName, asset.SHA256, got)
+	}
+	return nil
+}
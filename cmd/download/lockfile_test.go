@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// loadTestPackages writes config to packages.yml in a temporary directory and loads it.
+func loadTestPackages(t *testing.T, config string) (string, []*Package) {
+	t.Helper()
+	file := writeFile(t, tempDir(t), "packages.yml", config)
+	c, err := loadYAML(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, c.Packages
+}
+
+const lockfileTestConfig = `
+packages:
+  - name: tool
+    download_url:
+      linux: https://example.com/%v/tool-linux
+      mac: https://example.com/%v/tool-mac
+      windows: https://example.com/%v/tool.exe
+    version:
+      latest_command: [sh, -c, echo tool v1.2.0]
+      command: [sh, -c, echo tool 1.2.0]
+      format: 'tool (\S+)'
+`
+
+func TestLockfileRecordsUpToDatePackages(t *testing.T) {
+	a := newTestApp(t)
+	file, packages := loadTestPackages(t, lockfileTestConfig)
+	var err error
+	if a.lockfile, err = loadLockfile(lockfilePath([]string{file}, "")); err != nil {
+		t.Fatal(err)
+	}
+	install, err := a.Resolve(context.Background(), packages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if install {
+		t.Fatal("expect tool to be up to date")
+	}
+	if err := a.lockfile.Save(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := loadLockfile(filepath.Join(filepath.Dir(file), lockfileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := l.Packages["tool"]
+	if e == nil || e.Version != "v1.2.0" || len(e.Assets) != 0 {
+		t.Fatalf("expect tool v1.2.0 without assets, but %+v", e)
+	}
+}
+
+func TestLockfileRecord(t *testing.T) {
+	dir := tempDir(t)
+	download := writeFile(t, dir, "tool", "binary")
+	sum, _, err := hashFile(download)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		before  map[string]*LockedPackage
+		version string
+		install bool
+		assets  []string
+	}{
+		{name: "new install", version: "v1.2.0", install: true, assets: []string{"linux/amd64"}},
+		{name: "new up to date", version: "v1.2.0"},
+		{
+			name:    "up to date keeps assets",
+			before:  map[string]*LockedPackage{"tool": {Version: "v1.2.0", Assets: map[string]*LockedAsset{"darwin/arm64": {SHA256: "x"}}}},
+			version: "v1.2.0",
+			assets:  []string{"darwin/arm64"},
+		},
+		{
+			name:    "install adds assets",
+			before:  map[string]*LockedPackage{"tool": {Version: "v1.2.0", Assets: map[string]*LockedAsset{"darwin/arm64": {SHA256: "x"}}}},
+			version: "v1.2.0",
+			install: true,
+			assets:  []string{"darwin/arm64", "linux/amd64"},
+		},
+		{
+			name:    "new version drops assets",
+			before:  map[string]*LockedPackage{"tool": {Version: "v1.1.0", Assets: map[string]*LockedAsset{"darwin/arm64": {SHA256: "x"}}}},
+			version: "v1.2.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Lockfile{Packages: map[string]*LockedPackage{}, file: filepath.Join(tempDir(t), lockfileName)}
+			for name, e := range tt.before {
+				l.Packages[name] = e
+			}
+			p := &Package{Name: "tool", downloadFile: download, downloadURL: "https://example.com/tool"}
+			p.Version.Fixed = tt.version
+			if tt.install {
+				if err := l.record(p, "linux", "amd64"); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				l.recordVersion(p)
+			}
+			e := l.Packages["tool"]
+			if e == nil || e.Version != tt.version {
+				t.Fatalf("expect %s, but %+v", tt.version, e)
+			}
+			var assets []string
+			for key := range e.Assets {
+				assets = append(assets, key)
+			}
+			sort.Strings(assets)
+			if strings.Join(assets, ",") != strings.Join(tt.assets, ",") {
+				t.Errorf("expect assets %v, but %v", tt.assets, assets)
+			}
+			if a := e.Assets["linux/amd64"]; tt.install && (a.SHA256 != sum || a.URL != p.downloadURL) {
+				t.Errorf("expect %s of %s, but %+v", sum, p.downloadURL, a)
+			}
+		})
+	}
+}
+
+func TestLockfileUnchangedIsNotSaved(t *testing.T) {
+	file := filepath.Join(tempDir(t), lockfileName)
+	l := &Lockfile{Packages: map[string]*LockedPackage{"tool": {Version: "v1.2.0"}}, file: file}
+	p := &Package{Name: "tool"}
+	p.Version.Fixed = "v1.2.0"
+	l.recordVersion(p)
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(file); err == nil {
+		t.Fatal("expect no lockfile to be written")
+	}
+}
+
+func TestLockfileLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]*LockedPackage
+		fixed   string
+		err     string
+	}{
+		{name: "locked", entries: map[string]*LockedPackage{"tool": {Version: "v1.2.0"}}},
+		{name: "locked without assets", entries: map[string]*LockedPackage{"tool": {Version: "1.2.0"}}, fixed: "v1.2.0"},
+		{name: "missing", entries: map[string]*LockedPackage{"other": {Version: "v1.0.0"}}, err: "has no entry for tool"},
+		{name: "disagrees with version.fixed", entries: map[string]*LockedPackage{"tool": {Version: "v1.1.0"}}, fixed: "v1.2.0", err: "version.fixed is v1.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Lockfile{Packages: tt.entries, file: lockfileName}
+			p := &Package{Name: "tool"}
+			p.Version.Fixed = tt.fixed
+			err := l.lock([]*Package{p})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.locked != tt.entries["tool"] {
+				t.Errorf("expect tool to be locked to %+v, but %+v", tt.entries["tool"], p.locked)
+			}
+		})
+	}
+}
+
+func TestResolveLocked(t *testing.T) {
+	a := newTestApp(t)
+	_, packages := loadTestPackages(t, strings.Replace(lockfileTestConfig, "echo tool v1.2.0", "exit 1", 1))
+	p := packages[0]
+	p.locked = &LockedPackage{Version: "v1.2.0"}
+	install, err := a.Resolve(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if install || p.Version.latest != "v1.2.0" {
+		t.Fatalf("expect the locked v1.2.0 to be up to date, but install=%v latest=%s", install, p.Version.latest)
+	}
+}
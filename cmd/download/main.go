@@ -1,77 +1,457 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	yaml "github.com/goccy/go-yaml"
-	archiver "github.com/mholt/archiver/v3"
+	"golang.org/x/net/http/httpproxy"
 )
 
 type PackageDownloadURL struct {
-	Mac   string `yaml:"mac"`
-	Linux string `yaml:"linux"`
+	Mac     ArchURL  `yaml:"mac"`
+	Linux   ArchURL  `yaml:"linux"`
+	Windows ArchURL  `yaml:"windows"`
+	Parts   []string `yaml:"parts"`
+}
+
+func (d *PackageDownloadURL) For(myos, arch string) string {
+	switch myos {
+	case "linux":
+		return d.Linux.For(arch)
+	case "windows":
+		return d.Windows.For(arch)
+	}
+	return d.Mac.For(arch)
+}
+
+// ArchURL is either one string for all architectures,
+// or a map from GOARCH such as amd64 and arm64 to a string.
+type ArchURL struct {
+	all    string
+	byArch map[string]string
+}
+
+func (u *ArchURL) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		u.all = s
+		return nil
+	}
+	return unmarshal(&u.byArch)
+}
+
+func (u *ArchURL) For(arch string) string {
+	if u.all != "" {
+		return u.all
+	}
+	return u.byArch[arch]
+}
+
+// values returns every string of u keyed by arch, with "" for all architectures.
+func (u *ArchURL) values() map[string]string {
+	if u.all != "" {
+		return map[string]string{"": u.all}
+	}
+	return u.byArch
+}
+
+func (u ArchURL) generic() interface{} {
+	if u.all != "" {
+		return u.all
+	}
+	if len(u.byArch) == 0 {
+		return nil
+	}
+	m := map[string]interface{}{}
+	for arch, s := range u.byArch {
+		m[arch] = s
+	}
+	return m
 }
 
 type PackageVersion struct {
 	Command []string `yaml:"command"`
 	Format  string   `yaml:"format"`
 	Fixed   string   `yaml:"fixed"`
+	// Match selects which of multiple format matches is the version
+	Match string `yaml:"match"`
+	// JSONPath is a dotted path to the version in the JSON output of Command
+	JSONPath string `yaml:"json_path"`
+	// StripSuffix is removed from the end of the current version before comparison
+	StripSuffix string `yaml:"strip_suffix"`
+	// LatestCommand reports the latest version instead of the releases/latest redirect;
+	// its output is parsed with Format or JSONPath like Command
+	LatestCommand []string `yaml:"latest_command"`
+	// Canonicalize is git_describe to compare only the tag of "v1.2.3-5-gabcdef"
+	Canonicalize string `yaml:"canonicalize"`
+	// From is managed to run Command with the installed binary instead of one in PATH
+	From string `yaml:"from"`
+	// Source is where the latest version comes from, github (default), gitlab, json or html
+	Source string `yaml:"source"`
+	// LatestURL is fetched for the json and html sources
+	LatestURL string `yaml:"latest_url"`
+	// LatestJSONPath is a dotted path to the version in LatestURL for the json source
+	LatestJSONPath string `yaml:"latest_json_path"`
+	// LatestPattern is a regexp capturing the version in LatestURL for the html source,
+	// multiple matches are chosen by Match
+	LatestPattern string `yaml:"latest_pattern"`
+	// Constraint such as ">=1.2.0 <2.0.0" or "v1.2.*" selects the highest matching release
+	// from the releases API instead of the latest one, or the next one if its download is 404
+	Constraint string `yaml:"constraint"`
+	// Prerelease true also considers prereleases, and only considers nothing but them,
+	// choosing the highest release from the releases API instead of the latest one
+	Prerelease prereleaseOption `yaml:"prerelease"`
 
-	formatRegexp *regexp.Regexp
-	latest       string
-	current      string
+	formatRegexp      *regexp.Regexp
+	latestRegexp      *regexp.Regexp
+	constraints       []versionConstraint
+	stripSuffixRegexp *regexp.Regexp
+	latest            string
+	current           string
+	// fallbacks are the lower releases that also satisfy the constraint, highest first
+	fallbacks []string
 }
 
+const versionFromManaged = "managed"
+
+const canonicalizeGitDescribe = "git_describe"
+
+// gitDescribeSuffix is what git describe appends to the tag: commits since it,
+// the abbreviated hash, and optionally -dirty.
+var gitDescribeSuffix = regexp.MustCompile(`-\d+-g[0-9a-f]+(-dirty)?$`)
+
+const (
+	matchFirst = "first"
+	matchLast  = "last"
+	matchMax   = "max"
+)
+
+const (
+	typeBinary = "binary"
+	typeScript = "script"
+)
+
 type Package struct {
 	Name        string             `yaml:"name"`
+	Type        string             `yaml:"type"`
 	URL         string             `yaml:"url"`
 	DownloadURL PackageDownloadURL `yaml:"download_url"`
 	Version     PackageVersion     `yaml:"version"`
+	MinSize     int64              `yaml:"min_size"`
+	Checksum    PackageChecksum    `yaml:"checksum"`
+	Signature   PackageSignature   `yaml:"signature"`
+	// AssetPattern selects the download URL among release assets via the GitHub API
+	AssetPattern PackageDownloadURL  `yaml:"asset_pattern"`
+	AssetExclude string              `yaml:"asset_exclude"`
+	Completions  []PackageCompletion `yaml:"completions"`
+	// RetryOn lists HTTP status codes on which downloads are retried
+	RetryOn []int `yaml:"retry_on"`
+	// MaxExtractDepth caps how many nested archives are extracted, default 2
+	MaxExtractDepth int `yaml:"max_extract_depth"`
+	// BinDir overrides the directory this package is installed to
+	BinDir string `yaml:"bin_dir"`
+	// Archive overrides the archive format of the download, such as tar.gz or zip,
+	// or is none to install the download as is
+	Archive string `yaml:"archive"`
+	// TokenURL is fetched first, and TokenPattern extracts a one-time token
+	// from its response to substitute for %token in download_url
+	TokenURL     string `yaml:"token_url"`
+	TokenPattern string `yaml:"token_pattern"`
+	// OSMap and ArchMap rename GOOS and GOARCH for %os and %arch,
+	// such as darwin: macOS and amd64: x86_64
+	OSMap   map[string]string `yaml:"os_map"`
+	ArchMap map[string]string `yaml:"arch_map"`
+	// InstallAs is the file name of the installed binary, default Name
+	InstallAs string `yaml:"install_as"`
+	// Binary selects the binary in the archive by a glob on its path, or on its name
+	// if there is no slash, instead of taking the largest file
+	Binary string `yaml:"binary"`
+	// StripComponents removes leading directories from archive paths before matching Binary,
+	// like tar --strip-components
+	StripComponents int `yaml:"strip_components"`
+	// When lists conditions on os, arch, hostname and env.NAME that all must hold
+	// for the package to be installed, such as os=linux or env.CUDA_HOME
+	When []string `yaml:"when"`
+	// ExtractCommand is run by sh with the download and an output dir as $1 and $2
+	// instead of the built-in extraction
+	ExtractCommand string `yaml:"extract_command"`
+	// PostInstallStrip strips symbols from the binary before installing it
+	PostInstallStrip bool `yaml:"post_install_strip"`
+	// SkipVersionCheck skips running version.command with the installed binary
+	SkipVersionCheck bool `yaml:"skip_version_check"`
+	// KeepLatestN keeps copies of the newest N installed versions in bin_dir/.versions/NAME
+	KeepLatestN int `yaml:"keep_latest_n"`
+	// ChecksumFrom is where the sha256 of the download is found, only release_body for now
+	ChecksumFrom string `yaml:"checksum_from"`
+	// ChecksumPattern captures the sha256 of the asset %f in the checksum source
+	ChecksumPattern string `yaml:"checksum_pattern"`
+	// PreInstall runs before the download, and PostInstall after the binary is installed,
+	// with $DOWNLOAD_NAME, $DOWNLOAD_VERSION and $DOWNLOAD_BIN, the path of the installed binary
+	PreInstall  []string `yaml:"pre_install"`
+	PostInstall []string `yaml:"post_install"`
 
-	downloadFile       string
-	downloadBinaryFile string
-	locateBinaryFile   string
+	assetPatternRegexps map[string]*regexp.Regexp
+	assetExcludeRegexp  *regexp.Regexp
+	tokenRegexp         *regexp.Regexp
+	whenRules           []*whenRule
+	needInstall         bool
+	downloadURL         string
+	lastModified        string
+	extractDir          string
+	downloadFile        string
+	downloadBinaryFile  string
+	locateBinaryFile    string
+	locked              *LockedPackage
+	err                 error
+	// logs holds the log lines of p while it runs in parallel with other packages
+	logs *bytes.Buffer
 }
 
-func (p *Package) DownloadURLFor(myos string) string {
-	v := p.Version.latest
+func (p *Package) TargetVersion() string {
 	if p.Version.Fixed != "" {
-		v = p.Version.Fixed
+		return p.Version.Fixed
 	}
-	n := strings.TrimPrefix(v, "v")
-	u := p.URL + "/releases/download/"
-	if myos == "linux" {
-		u += p.DownloadURL.Linux
-	} else {
-		u += p.DownloadURL.Mac
+	return p.Version.latest
+}
+
+func (p *Package) DownloadURLFor(myos, arch string) string {
+	return p.expandURL(p.resolveURL(p.DownloadURL.For(myos, arch)), myos, arch)
+}
+
+// resolveURL resolves u relative to the release downloads of p unless it is absolute.
+func (p *Package) resolveURL(u string) string {
+	if !strings.Contains(u, "://") {
+		u = p.URL + "/releases/download/" + u
 	}
+	return u
+}
+
+// expandURL replaces %v, %n, %os, %arch, %owner and %repo in u.
+func (p *Package) expandURL(u, myos, arch string) string {
+	n := strings.TrimPrefix(p.TargetVersion(), "v")
 	u = strings.ReplaceAll(u, "%v", "v"+n)
 	u = strings.ReplaceAll(u, "%n", n)
+	if s, ok := p.OSMap[myos]; ok {
+		myos = s
+	}
+	if s, ok := p.ArchMap[arch]; ok {
+		arch = s
+	}
+	u = strings.ReplaceAll(u, "%os", myos)
+	u = strings.ReplaceAll(u, "%arch", arch)
+	if owner, repo, err := p.OwnerRepo(); err == nil {
+		u = strings.ReplaceAll(u, "%owner", owner)
+		u = strings.ReplaceAll(u, "%repo", repo)
+	}
 	return u
 }
 
+// OwnerRepo parses url such as https://github.com/owner/repo into owner and repo.
+func (p *Package) OwnerRepo() (string, string, error) {
+	parsed, err := url.Parse(p.URL)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%s: url %q is not in the form https://host/owner/repo", p.Name, p.URL)
+	}
+	return parts[0], parts[1], nil
+}
+
 func (p *Package) AlreadyLatestVersion() bool {
 	current := p.Version.current
-	latest := p.Version.latest
-	if p.Version.Fixed != "" {
-		latest = p.Version.Fixed
+	if p.Version.stripSuffixRegexp != nil {
+		current = p.Version.stripSuffixRegexp.ReplaceAllString(current, "")
 	}
+	if p.Version.Canonicalize == canonicalizeGitDescribe {
+		current = gitDescribeSuffix.ReplaceAllString(current, "")
+	}
+	latest := p.TargetVersion()
 	return strings.TrimPrefix(current, "v") == strings.TrimPrefix(latest, "v")
 }
 
+func (p *Package) assetPatternRegexp(myos, arch string) *regexp.Regexp {
+	if reg, ok := p.assetPatternRegexps[myos+"/"+arch]; ok {
+		return reg
+	}
+	return p.assetPatternRegexps[myos+"/"]
+}
+
+// CommandName returns the name p is installed as.
+func (p *Package) CommandName() string {
+	if p.InstallAs != "" {
+		return p.InstallAs
+	}
+	return p.Name
+}
+
+func (p *Package) SupportsOS(myos, arch string) bool {
+	return p.DownloadURL.For(myos, arch) != "" || p.assetPatternRegexp(myos, arch) != nil
+}
+
+func (p *Package) IsScript() bool {
+	return p.Type == typeScript
+}
+
 func (p *Package) Build() error {
+	switch p.Type {
+	case "", typeBinary, typeScript:
+	default:
+		return fmt.Errorf("%s: unknown type %q", p.Name, p.Type)
+	}
+	for _, d := range []ArchURL{p.DownloadURL.Mac, p.DownloadURL.Linux, p.DownloadURL.Windows} {
+		for _, u := range d.values() {
+			if strings.Contains(u, "%owner") || strings.Contains(u, "%repo") {
+				if _, _, err := p.OwnerRepo(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	p.assetPatternRegexps = map[string]*regexp.Regexp{}
+	for myos, d := range map[string]ArchURL{"linux": p.AssetPattern.Linux, "darwin": p.AssetPattern.Mac, "windows": p.AssetPattern.Windows} {
+		for arch, s := range d.values() {
+			reg, err := regexp.Compile(s)
+			if err != nil {
+				return err
+			}
+			p.assetPatternRegexps[myos+"/"+arch] = reg
+		}
+	}
+	if s := p.AssetExclude; s != "" {
+		reg, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		p.assetExcludeRegexp = reg
+	}
+	for _, c := range p.Completions {
+		if c.File == "" {
+			return fmt.Errorf("%s: completions.file is required", p.Name)
+		}
+		if c.Shell != "" && !shells[c.Shell] {
+			return fmt.Errorf("%s: unsupported completion shell %q", p.Name, c.Shell)
+		}
+	}
+	if p.Archive != "" && p.Archive != archiveNone && !supportedArchiveExt("."+strings.TrimPrefix(p.Archive, ".")) {
+		return fmt.Errorf("%s: %w", p.Name, unsupportedArchiveError(p.Archive))
+	}
+	if p.InstallAs != "" && (strings.ContainsAny(p.InstallAs, `/\`) || p.InstallAs == "." || p.InstallAs == "..") {
+		return fmt.Errorf("%s: install_as must be a file name, but %q", p.Name, p.InstallAs)
+	}
+	if _, err := path.Match(p.Binary, ""); err != nil {
+		return fmt.Errorf("%s: invalid binary %s, %w", p.Name, p.Binary, err)
+	}
+	if p.StripComponents < 0 {
+		return fmt.Errorf("%s: strip_components must not be negative, but %d", p.Name, p.StripComponents)
+	}
+	if p.KeepLatestN < 0 {
+		return fmt.Errorf("%s: keep_latest_n must not be negative, but %d", p.Name, p.KeepLatestN)
+	}
+	for _, w := range p.When {
+		r, err := parseWhenRule(w)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+		p.whenRules = append(p.whenRules, r)
+	}
+	if p.TokenURL != "" {
+		if p.TokenPattern == "" {
+			return fmt.Errorf("%s: token_pattern is required with token_url", p.Name)
+		}
+		reg, err := regexp.Compile(p.TokenPattern)
+		if err != nil {
+			return err
+		}
+		p.tokenRegexp = reg
+	}
+	if err := p.Checksum.build(); err != nil {
+		return fmt.Errorf("%s: %w", p.Name, err)
+	}
+	if err := p.Signature.build(); err != nil {
+		return fmt.Errorf("%s: %w", p.Name, err)
+	}
+	switch p.ChecksumFrom {
+	case "":
+	case checksumFromReleaseBody:
+		if _, _, err := p.OwnerRepo(); err != nil {
+			return err
+		}
+		if _, err := regexp.Compile(p.checksumPattern("")); err != nil {
+			return fmt.Errorf("%s: invalid checksum_pattern, %w", p.Name, err)
+		}
+	default:
+		return fmt.Errorf("%s: checksum_from must be %s, but %q", p.Name, checksumFromReleaseBody, p.ChecksumFrom)
+	}
+	switch p.Version.Canonicalize {
+	case "", canonicalizeGitDescribe:
+	default:
+		return fmt.Errorf("%s: version.canonicalize must be %s, but %q", p.Name, canonicalizeGitDescribe, p.Version.Canonicalize)
+	}
+	switch p.Version.From {
+	case "", versionFromManaged:
+	default:
+		return fmt.Errorf("%s: version.from must be %s, but %q", p.Name, versionFromManaged, p.Version.From)
+	}
+	if err := p.Version.buildSource(); err != nil {
+		return fmt.Errorf("%s: %w", p.Name, err)
+	}
+	if p.Version.Constraint != "" || p.Version.Prerelease != "" {
+		if p.Version.Fixed != "" || len(p.Version.LatestCommand) > 0 || (p.Version.Source != "" && p.Version.Source != sourceGitHub) {
+			return fmt.Errorf("%s: version.constraint and version.prerelease cannot be used with version.fixed, version.latest_command or version.source other than github", p.Name)
+		}
+		if _, _, err := p.OwnerRepo(); err != nil {
+			return err
+		}
+	}
+	if c := p.Version.Constraint; c != "" {
+		constraints, err := parseConstraint(c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+		p.Version.constraints = constraints
+	}
+	switch p.Version.Match {
+	case "", matchFirst, matchLast, matchMax:
+	default:
+		return fmt.Errorf("%s: version.match must be first, last or max, but %q", p.Name, p.Version.Match)
+	}
+	if s := p.Version.StripSuffix; s != "" {
+		reg, err := regexp.Compile("(?:" + s + ")$")
+		if err != nil {
+			return err
+		}
+		p.Version.stripSuffixRegexp = reg
+	}
 	f := p.Version.Format
 	if f == "" {
 		return nil
@@ -84,293 +464,2065 @@ func (p *Package) Build() error {
 	return nil
 }
 
-func loadYAML(file string) ([]*Package, error) {
+// schemaVersion is the newest config schema version this build understands.
+const schemaVersion = 1
+
+type Config struct {
+	Version   int        `yaml:"version"`
+	BeforeAll []string   `yaml:"before_all"`
+	AfterAll  []string   `yaml:"after_all"`
+	Packages  []*Package `yaml:"packages"`
+}
+
+func loadYAML(file string) (*Config, error) {
 	c, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	var y struct {
-		Packages []*Package `yaml:"packages"`
-	}
+	var y Config
 	if err := yaml.Unmarshal(c, &y); err != nil {
 		return nil, err
 	}
-	packages := y.Packages
-	for _, p := range packages {
+	if y.Version == 0 {
+		y.Version = 1
+	}
+	if y.Version < 0 {
+		return nil, fmt.Errorf("%s: invalid config version %d", file, y.Version)
+	}
+	if y.Version > schemaVersion {
+		return nil, fmt.Errorf("%s requires a newer go-download (config version %d, this go-download supports up to %d)", file, y.Version, schemaVersion)
+	}
+	var errs []error
+	for _, p := range y.Packages {
+		p.expandEnv()
+		if perrs := p.Validate(); len(perrs) > 0 {
+			errs = append(errs, perrs...)
+			continue
+		}
 		if err := p.Build(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &configError{file: file, errs: errs}
+	}
+	return &y, nil
+}
+
+// loadConfig merges files, where later files override packages of earlier ones by name,
+// and *.yml in dir, which must not define packages that are already defined.
+func loadConfig(files []string, dir string) (*Config, error) {
+	var dirFiles []string
+	if dir != "" {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no *.yml in %s", dir)
+		}
+		sort.Strings(matches)
+		dirFiles = matches
+	}
+	merged := &Config{Version: schemaVersion}
+	defined := map[string]string{}
+	index := map[string]int{}
+	for i, f := range append(files, dirFiles...) {
+		c, err := loadYAML(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range c.Packages {
+			if prev, ok := defined[p.Name]; ok {
+				if i >= len(files) {
+					return nil, fmt.Errorf("%s: package %s is already defined in %s", f, p.Name, prev)
+				}
+				merged.Packages[index[p.Name]] = p
+			} else {
+				index[p.Name] = len(merged.Packages)
+				merged.Packages = append(merged.Packages, p)
+			}
+			defined[p.Name] = f
+		}
+		merged.BeforeAll = append(merged.BeforeAll, c.BeforeAll...)
+		merged.AfterAll = append(merged.AfterAll, c.AfterAll...)
+	}
+	return merged, nil
+}
+
+// loadConfigOrDefault is loadConfig, with the default config file if neither files nor dir is given.
+func loadConfigOrDefault(files []string, dir string) (*Config, error) {
+	if len(files) == 0 && dir == "" {
+		file, err := defaultConfigFile()
+		if err != nil {
 			return nil, err
 		}
+		files = []string{file}
+	}
+	return loadConfig(files, dir)
+}
+
+const configDirUsage = "load every *.yml in this directory, sorted by name"
+
+// defaultConfigFile returns the first existing one of ./packages.yml,
+// $XDG_CONFIG_HOME/go-download/packages.yml, ~/.config/go-download/packages.yml
+// and go-download/packages.yml in the user config dir of the OS, such as %AppData% on Windows.
+func defaultConfigFile() (string, error) {
+	candidates := []string{"packages.yml"}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "go-download", "packages.yml"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "go-download", "packages.yml"))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		f := filepath.Join(dir, "go-download", "packages.yml")
+		found := false
+		for _, c := range candidates {
+			found = found || c == f
+		}
+		if !found {
+			candidates = append(candidates, f)
+		}
+	}
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("no packages.yml is given, and none of %s exists", strings.Join(candidates, ", "))
+}
+
+func runHook(command []string, env ...string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
+
+// runPackageHook runs the pre_install or post_install command of p, logging its output.
+func (a *App) runPackageHook(ctx context.Context, p *Package, name string, command []string) error {
+	if len(command) == 0 {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"DOWNLOAD_NAME="+p.Name,
+		"DOWNLOAD_VERSION="+p.TargetVersion(),
+		"DOWNLOAD_BIN="+a.TargetFile(p),
+	)
+	out, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			a.Log(p, "%s: %s", name, line)
+		}
 	}
-	return packages, nil
+	if err != nil {
+		return fmt.Errorf("%s failed, %w", name, err)
+	}
+	return nil
 }
 
 type App struct {
-	client           *http.Client
-	noRedirectClient *http.Client
-	workDir          string
-	os               string
-	binDir           string
+	client                *http.Client
+	noRedirectClient      *http.Client
+	workDir               string
+	os                    string
+	arch                  string
+	binDir                string
+	force                 bool
+	ifMissing             bool
+	compareRemote         bool
+	onlyIfChanged         bool
+	checkURLs             bool
+	stats                 Stats
+	state                 *State
+	releases              releaseCache
+	failOnUnsupported     bool
+	tolerateVersionErrors bool
+	extractTimeout        time.Duration
+	dryRun                bool
+	retries               int
+	color                 bool
+	skipVersionCheck      bool
+	cacheDir              string
+	logMu                 sync.Mutex
+	// lockfile records installed versions, nil if there is no config file to put it next to
+	lockfile *Lockfile
+	// ctx is cancelled on interrupt, aborting running requests
+	ctx       context.Context
+	interrupt context.CancelFunc
 }
 
-func NewApp() (*App, error) {
+func NewApp(opts *options) (*App, error) {
 	myos := ""
 	switch runtime.GOOS {
 	case "linux":
 		myos = "linux"
 	case "darwin":
 		myos = "darwin"
+	case "windows":
+		myos = "windows"
 	default:
 		return nil, fmt.Errorf("unsupport")
 	}
-	dir, err := ioutil.TempDir("", "download")
+	if opts.tmpDir != "" {
+		if info, err := os.Stat(opts.tmpDir); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", opts.tmpDir)
+		}
+	}
+	dir, err := ioutil.TempDir(opts.tmpDir, "download")
+	if err != nil {
+		return nil, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	binDir := defaultBinDir(myos, home)
+	if opts.binDir != "" {
+		dir := opts.binDir
+		if dir == "~" || strings.HasPrefix(dir, "~/") {
+			dir = home + dir[1:]
+		}
+		var err error
+		if binDir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.readOnly {
+		if err := prepareBinDir(binDir); err != nil {
+			return nil, err
+		}
+	}
+	state, err := loadState()
 	if err != nil {
 		return nil, err
 	}
-	home := os.Getenv("HOME")
-	if home == "" {
-		return nil, fmt.Errorf("HOME is not set")
+	cache := ""
+	if !opts.noCache {
+		if cache, err = cacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	// both clients share one transport so that keep-alive connections are reused across packages
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// http.ProxyFromEnvironment reads the environment only once per process
+	proxy := httpproxy.FromEnvironment().ProxyFunc()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) { return proxy(req.URL) }
+	transport.MaxIdleConnsPerHost = opts.maxIdleConnsPerHost
+	caCert := opts.caCert
+	if caCert == "" {
+		caCert = os.Getenv("DOWNLOAD_CA_CERT")
 	}
-	binDir := filepath.Join(home, "bin")
-	if _, err := os.Stat(binDir); err != nil {
-		if err := os.Mkdir(binDir, 0777); err != nil {
+	if caCert != "" {
+		pool, err := certPoolWith(caCert)
+		if err != nil {
 			return nil, err
 		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	var roundTripper http.RoundTripper = transport
+	netrc, err := loadNetrc()
+	if err != nil {
+		return nil, err
+	}
+	if netrc != nil {
+		roundTripper = &netrcTransport{base: roundTripper, netrc: netrc}
+	}
+	if token := githubToken(); token != "" {
+		roundTripper = &githubTokenTransport{base: roundTripper, token: token}
 	}
+	ctx, interrupt := context.WithCancel(context.Background())
 	return &App{
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: roundTripper,
+			Timeout:   opts.downloadTimeout,
 		},
 		noRedirectClient: &http.Client{
-			Timeout: 5 * time.Second,
+			Transport: roundTripper,
+			Timeout:   opts.headTimeout,
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
 		},
-		workDir: dir,
-		binDir:  binDir,
-		os:      myos,
+		workDir:               dir,
+		binDir:                binDir,
+		os:                    myos,
+		arch:                  runtime.GOARCH,
+		force:                 opts.force,
+		ifMissing:             opts.ifMissing,
+		compareRemote:         opts.compareRemote || opts.onlyIfChanged,
+		onlyIfChanged:         opts.onlyIfChanged,
+		checkURLs:             opts.checkURLs,
+		state:                 state,
+		failOnUnsupported:     opts.failOnUnsupported,
+		tolerateVersionErrors: opts.tolerateVersionErrs,
+		extractTimeout:        opts.extractTimeout,
+		ctx:                   ctx,
+		interrupt:             interrupt,
+		dryRun:                opts.dryRun,
+		retries:               opts.retries,
+		skipVersionCheck:      opts.skipVersionCheck,
+		cacheDir:              cache,
+		color:                 !opts.noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr),
 	}, nil
 }
 
+func prepareBinDir(dir string) error {
+	if info, err := os.Stat(dir); err != nil {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s exists, but is not a directory", dir)
+	}
+	// fail before downloading anything rather than when moving the binary
+	f, err := ioutil.TempFile(dir, ".download")
+	if err != nil {
+		return fmt.Errorf("%s is not writable, %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// defaultBinDir is $HOME/bin, or %LOCALAPPDATA%\go-download\bin on Windows.
+func defaultBinDir(myos, home string) string {
+	if myos != "windows" {
+		return filepath.Join(home, "bin")
+	}
+	dir := os.Getenv("LOCALAPPDATA")
+	if dir == "" {
+		dir = filepath.Join(home, "AppData", "Local")
+	}
+	return filepath.Join(dir, "go-download", "bin")
+}
+
+// certPoolWith returns the system roots plus the PEM certificates in file.
+func certPoolWith(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate in %s", file)
+	}
+	return pool, nil
+}
+
+func inPath(dir string) bool {
+	for _, p := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(p) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// BinDir returns the directory p is installed to.
+func (a *App) BinDir(p *Package) string {
+	dir := p.BinDir
+	if dir == "" {
+		return a.binDir
+	}
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, _ := os.UserHomeDir()
+		dir = home + dir[1:]
+	}
+	return dir
+}
+
+func (a *App) TargetFile(p *Package) string {
+	name := p.CommandName()
+	if a.os == "windows" && !p.IsScript() && !strings.EqualFold(filepath.Ext(name), ".exe") {
+		name += ".exe"
+	}
+	return filepath.Join(a.BinDir(p), name)
+}
+
 func (a *App) Cleanup() {
 	os.RemoveAll(a.workDir)
 }
 
-func (a *App) LatestVersion(p *Package) (string, error) {
-	u := fmt.Sprintf("%s/releases/latest", p.URL)
-	res, err := a.noRedirectClient.Get(u)
-	if err != nil {
-		return "", err
+func (a *App) LatestVersion(ctx context.Context, p *Package) (string, error) {
+	if len(p.Version.LatestCommand) > 0 {
+		return a.latestVersionFromCommand(ctx, p)
 	}
-	io.Copy(ioutil.Discard, res.Body)
-	res.Body.Close()
-	if res.StatusCode/100 != 3 {
-		return "", fmt.Errorf("expect 3XX response, but %s, %s", res.Status, u)
+	if len(p.Version.constraints) > 0 || p.Version.Prerelease != "" {
+		return a.latestVersionFromReleases(ctx, p)
 	}
-	if parts := strings.Split(res.Header.Get("Location"), "/"); len(parts) > 0 {
-		return parts[len(parts)-1], nil
+	switch p.Version.Source {
+	case sourceGitLab:
+		return a.latestVersionFromRedirect(ctx, p, p.URL+"/-/releases/permalink/latest")
+	case sourceJSON, sourceHTML:
+		return a.latestVersionFromURL(ctx, p)
 	}
-	return "", fmt.Errorf("response does not contain Location Header")
+	return a.latestVersionFromRedirect(ctx, p, p.URL+"/releases/latest")
 }
 
 var errSkip = errors.New("skip")
 
-func (a *App) CurrentVersion(p *Package) (string, error) {
-	if p.Version.formatRegexp == nil {
+func (a *App) CurrentVersion(ctx context.Context, p *Package) (string, error) {
+	if (p.Version.formatRegexp == nil && p.Version.JSONPath == "") || len(p.Version.Command) == 0 {
 		return "", errSkip
 	}
 	command := p.Version.Command
-	if _, err := exec.LookPath(command[0]); err != nil {
+	if p.Version.From == versionFromManaged {
+		target := a.TargetFile(p)
+		if _, err := os.Stat(target); err != nil {
+			return "", &exec.Error{Name: target, Err: exec.ErrNotFound}
+		}
+		command = append([]string{target}, command[1:]...)
+	}
+	return p.versionFromCommand(ctx, command)
+}
+
+func (p *Package) versionFromCommand(ctx context.Context, command []string) (string, error) {
+	stdout, combined, err := runVersionCommand(ctx, command)
+	if errors.Is(err, exec.ErrNotFound) {
 		return "", err
 	}
-	out, err := exec.Command(command[0], command[1:]...).CombinedOutput()
-	ress := p.Version.formatRegexp.FindAllStringSubmatch(string(out), -1)
-	if len(ress) == 0 {
+	v, parseErr := p.Version.parse(stdout, combined)
+	if parseErr != nil {
+		if p.IsScript() {
+			// version detection is optional for scripts
+			return "", errSkip
+		}
 		if err != nil {
 			return "", err
 		}
-		return "", fmt.Errorf("cannot determine current version, check version format")
+		return "", fmt.Errorf("cannot determine current version, %w", parseErr)
 	}
-	res := ress[0]
-	return res[1], nil
+	return v, nil
 }
 
-func (a *App) Download(p *Package) (string, error) {
-	u := p.DownloadURLFor(a.os)
-	downloadFile := filepath.Join(a.workDir, p.Name, filepath.Base(u))
-	if err := os.MkdirAll(filepath.Dir(downloadFile), 0777); err != nil {
-		return "", err
-	}
-	file, err := os.Create(downloadFile)
+func (a *App) latestVersionFromCommand(ctx context.Context, p *Package) (string, error) {
+	stdout, combined, err := runVersionCommand(ctx, p.Version.LatestCommand)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("latest_command failed, %w", err)
 	}
-	err = func() error {
-		res, err := a.client.Get(u)
-		if err != nil {
-			return err
-		}
-		defer res.Body.Close()
-		if _, err := io.Copy(file, res.Body); err != nil {
-			return err
-		}
-		if res.StatusCode/100 != 2 {
-			return errors.New(res.Status)
+	if p.Version.formatRegexp == nil && p.Version.JSONPath == "" {
+		if v := strings.TrimSpace(string(stdout)); v != "" {
+			return v, nil
 		}
-		return nil
-	}()
-	file.Close()
+		return "", errors.New("latest_command printed nothing")
+	}
+	v, err := p.Version.parse(stdout, combined)
 	if err != nil {
-		os.Remove(downloadFile)
-		return "", err
+		return "", fmt.Errorf("cannot determine latest version, %w", err)
 	}
-	return downloadFile, nil
+	return v, nil
 }
 
-func (a *App) BinaryFile(p *Package) (string, error) {
-	f := p.downloadFile
-	if !(strings.HasSuffix(f, ".tar.gz") || strings.HasSuffix(f, ".tgz") || strings.HasSuffix(f, ".zip")) {
-		return f, nil
+// runVersionCommand runs command and returns its stdout and its stdout followed by stderr.
+func runVersionCommand(ctx context.Context, command []string) ([]byte, []byte, error) {
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, nil, err
 	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.Bytes(), append(stdout.Bytes(), stderr.Bytes()...), err
+}
 
-	extractDir := filepath.Join(filepath.Dir(f), "__extract")
-	if err := os.Mkdir(extractDir, 0777); err != nil {
-		return "", err
+// parse extracts a version from the output of a version command,
+// with json_path from stdout, or with format from stdout and stderr.
+func (v *PackageVersion) parse(stdout, combined []byte) (string, error) {
+	if v.JSONPath != "" {
+		s, err := jsonPathValue(stdout, v.JSONPath)
+		if err == nil {
+			return s, nil
+		}
+		if v.formatRegexp == nil {
+			return "", err
+		}
 	}
-	if err := archiver.Unarchive(f, extractDir); err != nil {
-		return "", err
+	ress := v.formatRegexp.FindAllStringSubmatch(string(combined), -1)
+	if len(ress) == 0 {
+		return "", errors.New("check version format")
 	}
-
-	maxSize := int64(0)
-	binaryFile := ""
-	err := filepath.Walk(extractDir, func(path string, info os.FileInfo, err error) error {
-		if size := info.Size(); size > maxSize {
-			binaryFile = path
-			maxSize = size
+	var versions []string
+	for _, res := range ress {
+		if len(res) >= 2 && res[1] != "" {
+			versions = append(versions, res[1])
 		}
-		return nil
-	})
-	if err != nil {
-		return "", err
 	}
-	return binaryFile, nil
-}
-
+	if len(versions) == 0 {
+		return "", errors.New("version format captured an empty string")
+	}
+	switch v.Match {
+	case matchLast:
+		return versions[len(versions)-1], nil
+	case matchMax:
+		max := versions[0]
+		for _, version := range versions[1:] {
+			if compareVersions(version, max) > 0 {
+				max = version
+			}
+		}
+		return max, nil
+	}
+	return versions[0], nil
+}
+
+// jsonPathValue returns the value at a dotted path such as "build.version" in JSON.
+func jsonPathValue(data []byte, path string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("invalid JSON output, %w", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("json_path %s does not exist", path)
+			}
+			v = node[i]
+		default:
+			v = nil
+		}
+		if v == nil {
+			return "", fmt.Errorf("json_path %s does not exist", path)
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return "", fmt.Errorf("json_path %s is empty", path)
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("json_path %s is not a string", path)
+}
+
+var archiveExts = []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.bz2", ".tbz2", ".zip"}
+
+var contentTypeExts = map[string]string{
+	"application/zip":    ".zip",
+	"application/gzip":   ".tar.gz",
+	"application/x-gzip": ".tar.gz",
+}
+
+// downloadFileName names the downloaded file after the URL base, or falls back
+// to <name>-<version><ext> when the URL base is not a usable filename.
+func downloadFileName(p *Package, u string, res *http.Response) string {
+	base := u
+	if i := strings.IndexAny(base, "?#"); i >= 0 {
+		base = base[:i]
+	}
+	// the path of an oci:// URL is a repository and a tag, not a file name
+	if !strings.HasSuffix(base, "/") && !strings.HasPrefix(base, "oci://") {
+		base = path.Base(base)
+		v := p.TargetVersion()
+		switch base {
+		case "", ".", "/", "download", v, strings.TrimPrefix(v, "v"):
+		default:
+			return base
+		}
+	}
+	if _, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); name != "." && name != "/" && name != "" {
+			return name
+		}
+	}
+	ext := ""
+	if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil {
+		ext = contentTypeExts[mediaType]
+	}
+	name := p.Name
+	if v := p.TargetVersion(); v != "" {
+		name += "-" + v
+	}
+	return name + ext
+}
+
+// fetchers fetch download URLs whose scheme is not http(s), with the client of the App
+// so that they share its proxy, CA certificates, timeouts and credentials.
+// Optional ones are registered by files guarded with build tags, see s3.go and oci.go.
+var fetchers = map[string]func(ctx context.Context, client *http.Client, u *url.URL) (*http.Response, error){}
+
+var optionalSchemes = map[string]string{
+	"oci": "oci",
+	"s3":  "s3",
+}
+
+const defaultRetries = 3
+
+// retryWait is the first backoff of do, doubled on each retry.
+var retryWait = time.Second
+
+// retryable reports whether a response with code is worth retrying,
+// one of p.RetryOn if set, or 429 and 5XX.
+func (p *Package) retryable(code int) bool {
+	if p.RetryOn == nil {
+		return code == http.StatusTooManyRequests || code/100 == 5
+	}
+	for _, c := range p.RetryOn {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// get GETs u with a.client, see do.
+func (a *App) get(ctx context.Context, p *Package, u string, header http.Header) (*http.Response, error) {
+	return a.do(ctx, p, a.client, u, header)
+}
+
+// do GETs u with client up to -retries times, and retries on errors and
+// retryable responses with exponential backoff from 1s plus jitter.
+func (a *App) do(ctx context.Context, p *Package, client *http.Client, u string, header http.Header) (*http.Response, error) {
+	wait := retryWait
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := client.Do(req)
+		if attempt >= a.retries {
+			return res, err
+		}
+		if err == nil {
+			if !p.retryable(res.StatusCode) {
+				return res, nil
+			}
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			err = errors.New(res.Status)
+		}
+		sleep := wait + time.Duration(rand.Int63n(int64(wait/2)))
+		a.Log(p, "attempt %d failed, %s, retry in %s", attempt, err, sleep.Round(time.Millisecond))
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return nil, err
+		}
+		wait *= 2
+	}
+}
+
+func (a *App) fetch(ctx context.Context, p *Package, u string, header http.Header) (*http.Response, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return a.get(ctx, p, u, header)
+	}
+	if fetch, ok := fetchers[parsed.Scheme]; ok {
+		return fetch(ctx, a.client, parsed)
+	}
+	if tag, ok := optionalSchemes[parsed.Scheme]; ok {
+		return nil, fmt.Errorf("%s:// URLs are not supported by this build, rebuild with -tags %s", parsed.Scheme, tag)
+	}
+	return nil, fmt.Errorf("unsupported URL scheme %q, %s", parsed.Scheme, u)
+}
+
+func (a *App) DownloadURL(ctx context.Context, p *Package) (string, error) {
+	if asset := p.lockedAsset(a.os, a.arch); asset != nil && asset.URL != "" {
+		return asset.URL, nil
+	}
+	var u string
+	if p.assetPatternRegexp(a.os, a.arch) != nil {
+		var err error
+		if u, err = a.SelectAsset(ctx, p); err != nil {
+			return "", err
+		}
+	} else {
+		u = p.DownloadURLFor(a.os, a.arch)
+	}
+	if p.TokenURL != "" {
+		token, err := a.downloadToken(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		u = strings.ReplaceAll(u, "%token", url.QueryEscape(token))
+	}
+	return u, nil
+}
+
+// downloadToken gets the token_url page and extracts a download token from it,
+// the first submatch of token_pattern if any or the whole match otherwise.
+func (a *App) downloadToken(ctx context.Context, p *Package) (string, error) {
+	u := p.expandURL(p.TokenURL, a.os, a.arch)
+	res, err := a.fetch(ctx, p, u, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return "", fmt.Errorf("expect 2XX response, but %s, %s", res.Status, u)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	m := p.tokenRegexp.FindSubmatch(body)
+	if m == nil {
+		return "", fmt.Errorf("token_pattern %s does not match the response of %s", p.tokenRegexp, u)
+	}
+	if len(m) > 1 {
+		return string(m[1]), nil
+	}
+	return string(m[0]), nil
+}
+
+var errNotModified = errors.New("not modified")
+
+// statusError is a download that got a non-2XX response.
+type statusError struct {
+	status string
+	code   int
+	url    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("expect 2XX response, but %s, %s", e.status, e.url)
+}
+
+func (a *App) Download(ctx context.Context, p *Package) (string, error) {
+	u := p.downloadURL
+	if file, ok := a.cachedDownload(ctx, p, u); ok {
+		return file, nil
+	}
+	urls := []string{u}
+	if parts := p.DownloadURL.Parts; len(parts) > 0 {
+		// split volumes are concatenated into a file named after u
+		urls = nil
+		for _, part := range parts {
+			urls = append(urls, u+part)
+		}
+	}
+	header := http.Header{}
+	if lastModified := a.state.lastModified(u); lastModified != "" && !a.force && len(urls) == 1 {
+		if _, err := os.Stat(a.TargetFile(p)); err == nil {
+			header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	downloadFile := ""
+	var file *os.File
+	start := time.Now()
+	written := int64(0)
+	err := func() error {
+		for _, partURL := range urls {
+			res, err := a.fetch(ctx, p, partURL, header)
+			if err != nil {
+				return err
+			}
+			if res.StatusCode == http.StatusNotModified {
+				res.Body.Close()
+				return errNotModified
+			}
+			if res.StatusCode/100 != 2 {
+				io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+				return &statusError{status: res.Status, code: res.StatusCode, url: partURL}
+			}
+			p.lastModified = res.Header.Get("Last-Modified")
+			if file == nil {
+				downloadFile = filepath.Join(a.workDir, p.Name, downloadFileName(p, u, res))
+				if err := os.MkdirAll(filepath.Dir(downloadFile), 0777); err != nil {
+					res.Body.Close()
+					return err
+				}
+				if file, err = os.Create(downloadFile); err != nil {
+					res.Body.Close()
+					return err
+				}
+			}
+			progress := newProgressWriter(res.ContentLength, isTerminal(os.Stderr), func(s string) { a.Progress(p, "%s", s) })
+			n, err := io.Copy(file, io.TeeReader(res.Body, progress))
+			written += n
+			res.Body.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if file != nil {
+		file.Close()
+	}
+	a.stats.addDownload(written, time.Since(start))
+	if err == nil {
+		err = a.VerifyFile(ctx, p, downloadFile)
+	}
+	if err != nil {
+		if file != nil {
+			os.Remove(downloadFile)
+		}
+		return "", err
+	}
+	return downloadFile, nil
+}
+
+// VerifyFile runs the verifications configured for p against file.
+func (a *App) VerifyFile(ctx context.Context, p *Package, file string) error {
+	if p.MinSize > 0 {
+		if err := checkMinSize(file, p.MinSize); err != nil {
+			return err
+		}
+	}
+	if err := a.verifyChecksum(ctx, p, file); err != nil {
+		return err
+	}
+	if err := a.VerifySignature(ctx, p, file); err != nil {
+		return err
+	}
+	if p.ChecksumFrom == checksumFromReleaseBody {
+		if err := a.verifyReleaseBodyChecksum(ctx, p, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkMinSize(file string, minSize int64) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if size := info.Size(); size < minSize {
+		return fmt.Errorf("downloaded file is too small, expect at least %d bytes, but %d bytes", minSize, size)
+	}
+	return nil
+}
+
+const defaultMaxExtractDepth = 2
+
+func (a *App) BinaryFile(p *Package) (string, error) {
+	f := p.downloadFile
+	if p.IsScript() {
+		return f, nil
+	}
+	if p.ExtractCommand != "" {
+		extractDir := filepath.Join(filepath.Dir(p.downloadFile), "__extract")
+		return a.runExtractCommand(p, f, extractDir)
+	}
+	maxDepth := p.MaxExtractDepth
+	if maxDepth == 0 {
+		maxDepth = defaultMaxExtractDepth
+	}
+	// an archive may wrap another archive, such as a tarball in a zip
+	for depth := 0; ; depth++ {
+		var ext string
+		if depth == 0 && p.Archive != "" {
+			if p.Archive == archiveNone {
+				return f, nil
+			}
+			ext = "." + strings.TrimPrefix(p.Archive, ".")
+			if ext != gzipExt {
+				ext = archiveExt(ext)
+			}
+		} else {
+			ext = archiveExt(f)
+			detected, err := sniffArchive(f)
+			if err != nil {
+				return "", err
+			}
+			if detected != "" && detected != ext {
+				a.Log(p, "warning: %s looks like %s, extract it as %s", filepath.Base(f), detected, detected)
+				ext = detected
+			}
+		}
+		if ext == "" && strings.HasSuffix(f, gzipExt) {
+			ext = gzipExt
+		}
+		if ext == "" {
+			if unsupported := unsupportedArchiveExt(f); unsupported != "" {
+				return "", unsupportedArchiveError(unsupported)
+			}
+			return f, nil
+		}
+		if depth == maxDepth {
+			return "", fmt.Errorf("%s is nested deeper than max_extract_depth %d", filepath.Base(f), maxDepth)
+		}
+		extractDir := filepath.Join(filepath.Dir(p.downloadFile), "__extract")
+		if depth > 0 {
+			extractDir += strconv.Itoa(depth)
+		}
+		var err error
+		if ext == gzipExt {
+			f, err = a.gunzip(p, f, extractDir)
+		} else {
+			f, err = a.extract(p, f, ext, extractDir)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// extract unarchives file into extractDir, and returns the binary candidate in it.
+func (a *App) extract(p *Package, file, ext, extractDir string) (string, error) {
+	if err := os.Mkdir(extractDir, 0777); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	err := a.unarchive(p, file, ext, extractDir)
+	a.stats.addExtract(time.Since(start))
+	if err != nil {
+		return "", err
+	}
+	p.extractDir = extractDir
+	return selectBinary(p, extractDir)
+}
+
+// runExtractCommand runs extract_command with the archive and the output dir as $1 and $2,
+// and returns the binary candidate in the output dir.
+func (a *App) runExtractCommand(p *Package, file, extractDir string) (string, error) {
+	if err := os.Mkdir(extractDir, 0777); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	cmd := exec.Command("sh", "-c", p.ExtractCommand, "sh", file, extractDir)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	a.stats.addExtract(time.Since(start))
+	if err != nil {
+		return "", fmt.Errorf("extract_command failed, %w", err)
+	}
+	p.extractDir = extractDir
+	binaryFile, err := selectBinary(p, extractDir)
+	if err == nil && (binaryFile == "" || binaryFile == extractDir) {
+		return "", errors.New("extract_command produced no files")
+	}
+	return binaryFile, err
+}
+
+// selectBinary returns the binary candidate in dir, the file matching binary if set,
+// or the one largestFile picks.
+func selectBinary(p *Package, dir string) (string, error) {
+	if p.Binary != "" {
+		matches, err := matchBinary(p.Binary, dir, p.StripComponents)
+		if err != nil {
+			return "", err
+		}
+		switch len(matches) {
+		case 1:
+			return matches[0], nil
+		case 0:
+			// the binary may be in an archive nested in this one
+			if largest, err := largestFile(dir); err == nil && archiveExt(largest) != "" {
+				return largest, nil
+			}
+			return "", fmt.Errorf("no file matches binary %s", p.Binary)
+		}
+		var rels []string
+		for _, m := range matches {
+			rel, _ := filepath.Rel(dir, m)
+			rels = append(rels, filepath.ToSlash(rel))
+		}
+		return "", fmt.Errorf("multiple files match binary %s: %s", p.Binary, strings.Join(rels, ", "))
+	}
+	return largestFile(dir)
+}
+
+// matchBinary returns the files in dir matching pattern, a glob on the path
+// relative to dir without strip leading directories, or on the base name if pattern has no slash.
+func matchBinary(pattern, dir string, strip int) ([]string, error) {
+	var matches []string
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strip > 0 {
+			parts := strings.SplitN(rel, "/", strip+1)
+			if len(parts) <= strip {
+				return nil
+			}
+			rel = parts[strip]
+		}
+		if !strings.Contains(pattern, "/") {
+			rel = path.Base(rel)
+		}
+		if ok, err := path.Match(pattern, rel); err != nil {
+			return fmt.Errorf("invalid binary %s, %w", pattern, err)
+		} else if ok {
+			matches = append(matches, file)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// textExts and textNames are files in archives that are documentation rather than binaries.
+var textExts = map[string]bool{
+	".md": true, ".txt": true, ".rst": true, ".adoc": true, ".html": true, ".css": true,
+	".json": true, ".yml": true, ".yaml": true, ".toml": true, ".1": true,
+	".sha256": true, ".sig": true, ".asc": true, ".pem": true,
+}
+
+var textNames = map[string]bool{
+	"README": true, "LICENSE": true, "LICENCE": true, "COPYING": true, "NOTICE": true,
+	"CHANGELOG": true, "CHANGES": true, "AUTHORS": true, "CONTRIBUTING": true,
+}
+
+func isTextFile(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	name := strings.ToUpper(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	return textExts[ext] || textNames[name]
+}
+
+// largestFile returns the largest executable regular file in dir,
+// or the largest regular file that is not documentation if none is executable,
+// as archives such as zip may not keep mode bits.
+func largestFile(dir string) (string, error) {
+	var executable, other string
+	var executableSize, otherSize int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || isTextFile(path) {
+			return nil
+		}
+		size := info.Size()
+		if info.Mode()&0111 != 0 {
+			if executable == "" || size > executableSize {
+				executable, executableSize = path, size
+			}
+		} else if other == "" || size > otherSize {
+			other, otherSize = path, size
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if executable != "" {
+		return executable, nil
+	}
+	if other != "" {
+		return other, nil
+	}
+	return "", errors.New("no file in the archive looks like a binary")
+}
+
+func hashFile(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// CompareInstalled reports whether the downloaded binary differs from the installed one.
+func (a *App) CompareInstalled(p *Package) (bool, error) {
+	installed := a.TargetFile(p)
+	if _, err := os.Stat(installed); os.IsNotExist(err) {
+		a.Log(p, "compare: %s is not installed", installed)
+		return true, nil
+	}
+	oldHash, oldSize, err := hashFile(installed)
+	if err != nil {
+		return false, err
+	}
+	newHash, newSize, err := hashFile(p.downloadBinaryFile)
+	if err != nil {
+		return false, err
+	}
+	if oldHash == newHash {
+		a.Log(p, "compare: identical (sha256 %s)", newHash)
+		return false, nil
+	}
+	a.Log(p, "compare: changed (size %d -> %d, sha256 %s -> %s)", oldSize, newSize, oldHash, newHash)
+	return true, nil
+}
+
 func (a *App) LocateBinaryFile(p *Package) (string, error) {
 	source := p.downloadBinaryFile
-	if err := os.Chmod(source, 0755); err != nil {
+	if a.os != "windows" {
+		if err := os.Chmod(source, 0755); err != nil {
+			return "", err
+		}
+	}
+	if dir := a.BinDir(p); dir != a.binDir {
+		if err := prepareBinDir(dir); err != nil {
+			return "", err
+		}
+		if !inPath(dir) {
+			a.Log(p, "warning: %s is not in PATH", dir)
+		}
+	}
+	target := a.TargetFile(p)
+	if err := moveFile(source, target); err != nil {
 		return "", err
 	}
-	target := filepath.Join(a.binDir, p.Name)
-	if err := os.Rename(source, target); err != nil {
-		return "", err
+	return target, nil
+}
+
+// moveFile renames source to target, falling back to copying
+// when they are on different file systems.
+func moveFile(source, target string) error {
+	err := os.Rename(source, target)
+	if err == nil {
+		return nil
+	}
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(source, target); err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
+
+// copyFile copies source to target through a temporary file,
+// so that target never appears partially written.
+func copyFile(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := target + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// green colors s unless colors are disabled.
+func (a *App) green(s string) string {
+	if !a.color {
+		return s
+	}
+	return "\033[1;32m" + s + "\033[m"
+}
+
+func (a *App) Log(p *Package, format string, args ...interface{}) {
+	line := fmt.Sprintf(p.Name+": "+format+"\n", args...)
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	if p.logs != nil {
+		p.logs.WriteString(line)
+		return
+	}
+	os.Stderr.WriteString(line)
+}
+
+// Progress writes a progress line of p right away, even while the other lines of p are held,
+// as progress is pointless once it is over.
+func (a *App) Progress(p *Package, format string, args ...interface{}) {
+	line := fmt.Sprintf(p.Name+": "+format+"\n", args...)
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	os.Stderr.WriteString(line)
+}
+
+// flushLog writes the held log lines of p at once, so that they are not interleaved
+// with those of other packages.
+func (a *App) flushLog(p *Package) {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	if p.logs != nil {
+		os.Stderr.Write(p.logs.Bytes())
+		p.logs = nil
+	}
+}
+
+func (a *App) Resolve(ctx context.Context, p *Package) (bool, error) {
+	if cond := a.unmetCondition(p); cond != "" {
+		a.Log(p, "skip, when %s does not hold", cond)
+		return false, nil
+	}
+	if !p.SupportsOS(a.os, a.arch) {
+		if a.failOnUnsupported {
+			return false, fmt.Errorf("no download_url for %s/%s", a.os, a.arch)
+		}
+		a.Log(p, "skip, no download_url for %s/%s", a.os, a.arch)
+		return false, nil
+	}
+	if a.ifMissing {
+		if _, err := os.Stat(a.TargetFile(p)); err == nil {
+			a.Log(p, "skip, %s exists", tildePath(a.TargetFile(p)))
+			return false, nil
+		}
+	}
+	var err error
+	p.Version.current, err = a.CurrentVersion(ctx, p)
+	if err == nil {
+		a.Log(p, "current version is %s", p.Version.current)
+	} else if errors.Is(err, exec.ErrNotFound) {
+		a.Log(p, "not installed")
+	} else if err == errSkip {
+		// noop
+	} else {
+		return false, err
+	}
+	if p.IsScript() && p.URL == "" && len(p.Version.LatestCommand) == 0 {
+		// a raw script URL has no release to resolve, so always install it
+		return true, nil
+	}
+	if p.locked != nil {
+		p.Version.latest = p.locked.Version
+		a.Log(p, "locked version is %s", p.Version.latest)
+	} else {
+		if p.Version.latest, err = a.LatestVersion(ctx, p); err != nil {
+			if a.tolerateVersionErrors && p.Version.current != "" {
+				a.Log(p, "warning: failed to get the latest version, keep %s: %v", p.Version.current, err)
+				return false, nil
+			}
+			return false, err
+		}
+		a.Log(p, "latest version is %s", p.Version.latest)
+	}
+	if p.AlreadyLatestVersion() && !a.ifMissing {
+		if !a.force {
+			if a.dryRun {
+				a.Log(p, "up to date (%s)", p.TargetVersion())
+			} else {
+				a.Log(p, "already have the latest version")
+			}
+			if a.lockfile != nil && p.locked == nil {
+				a.lockfile.recordVersion(p)
+			}
+			return false, nil
+		}
+		a.Log(p, "already have the latest version, but reinstall it")
+	}
+	return true, nil
+}
+
+func (a *App) Install(ctx context.Context, p *Package) error {
+	if err := a.runPackageHook(ctx, p, "pre_install", p.PreInstall); err != nil {
+		return err
+	}
+	var err error
+	for {
+		if p.downloadURL, err = a.DownloadURL(ctx, p); err != nil {
+			return err
+		}
+		a.Log(p, a.green("downloading %s"), p.downloadURL)
+		p.downloadFile, err = a.Download(ctx, p)
+		var se *statusError
+		if !errors.As(err, &se) || se.code != http.StatusNotFound || len(p.Version.fallbacks) == 0 || p.Version.Fixed != "" {
+			break
+		}
+		// the asset of a yanked release is gone, so try the next satisfying release
+		skipped := p.Version.latest
+		p.Version.latest, p.Version.fallbacks = p.Version.fallbacks[0], p.Version.fallbacks[1:]
+		a.Log(p, "warning: skip %s, its download is %s, fall back to %s", skipped, se.status, p.Version.latest)
+		if p.AlreadyLatestVersion() && !a.force {
+			a.Log(p, "already have %s", p.Version.latest)
+			return nil
+		}
+	}
+	if err != nil {
+		if err == errNotModified {
+			a.Log(p, "not modified since %s, keep the installed binary", a.state.lastModified(p.downloadURL))
+			return nil
+		}
+		return err
+	}
+	if err := a.verifyLocked(p); err != nil {
+		return err
+	}
+	if err := a.storeCache(p); err != nil {
+		a.Log(p, "warning: failed to cache %s, %v", filepath.Base(p.downloadFile), err)
 	}
-	return target, nil
-}
-
-func (a *App) Log(p *Package, format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, p.Name+": "+format+"\n", args...)
-}
-
-func (a *App) Run(p *Package) error {
-	var err error
-	p.Version.current, err = a.CurrentVersion(p)
-	if err == nil {
-		a.Log(p, "current version is %s", p.Version.current)
-	} else if errors.Is(err, exec.ErrNotFound) {
-		a.Log(p, "not installed")
-	} else if err == errSkip {
-		// noop
-	} else {
+	if p.downloadBinaryFile, err = a.BinaryFile(p); err != nil {
+		return err
+	}
+	if err := a.CheckArch(p); err != nil {
+		return err
+	}
+	a.Strip(p)
+	if a.compareRemote {
+		changed, err := a.CompareInstalled(p)
+		if err != nil {
+			return err
+		}
+		if !changed && a.onlyIfChanged {
+			a.Log(p, "skip installing the identical binary")
+			return nil
+		}
+	}
+	if p.locateBinaryFile, err = a.LocateBinaryFile(p); err != nil {
+		return err
+	}
+	if err := a.CheckVersion(ctx, p); err != nil {
+		return err
+	}
+	if err := a.InstallCompletions(p); err != nil {
 		return err
 	}
-	if p.Version.latest, err = a.LatestVersion(p); err != nil {
+	if err := a.runPackageHook(ctx, p, "post_install", p.PostInstall); err != nil {
 		return err
 	}
-	a.Log(p, "latest version is %s", p.Version.latest)
-	if p.AlreadyLatestVersion() {
-		a.Log(p, "already have the latest version")
+	if err := a.KeepVersion(p); err != nil {
+		a.Log(p, "warning: failed to keep %s in %s, %v", p.TargetVersion(), a.versionsDir(p), err)
+	}
+	if len(p.DownloadURL.Parts) == 0 {
+		a.state.setLastModified(p.downloadURL, p.lastModified)
+	}
+	if a.lockfile != nil && p.locked == nil {
+		if err := a.lockfile.record(p, a.os, a.arch); err != nil {
+			a.Log(p, "warning: failed to record %s in %s, %v", p.TargetVersion(), lockfileName, err)
+		}
+	}
+	a.Log(p, a.green("installed %s %s"), p.locateBinaryFile, p.Version.latest)
+	return nil
+}
+
+// CheckVersion runs version.command with the installed binary,
+// and confirms it reports the version just installed.
+func (a *App) CheckVersion(ctx context.Context, p *Package) error {
+	if a.skipVersionCheck || p.SkipVersionCheck || p.IsScript() {
+		return nil
+	}
+	if (p.Version.formatRegexp == nil && p.Version.JSONPath == "") || len(p.Version.Command) == 0 {
+		return nil
+	}
+	command := p.Version.Command
+	if p.Version.From != versionFromManaged && filepath.Base(command[0]) != p.CommandName() {
+		// version.command runs another program, such as go for golang
 		return nil
 	}
+	command = append([]string{p.locateBinaryFile}, command[1:]...)
+	v, err := p.versionFromCommand(ctx, command)
+	if err != nil {
+		return fmt.Errorf("installed %s does not work, %w", p.locateBinaryFile, err)
+	}
+	current := p.Version.current
+	p.Version.current = v
+	ok := p.AlreadyLatestVersion()
+	p.Version.current = current
+	if !ok {
+		return fmt.Errorf("installed %s reports version %s, but expect %s", p.locateBinaryFile, v, p.TargetVersion())
+	}
+	return nil
+}
 
-	a.Log(p, "\033[1;32mdownloading %s\033[m", p.DownloadURLFor(a.os))
-	if p.downloadFile, err = a.Download(p); err != nil {
+// DryRun reports what Install would do, optionally checking the download URL with HEAD.
+func (a *App) DryRun(ctx context.Context, p *Package) error {
+	u, err := a.DownloadURL(ctx, p)
+	if err != nil {
 		return err
 	}
-	if p.downloadBinaryFile, err = a.BinaryFile(p); err != nil {
+	a.Log(p, "would download %s -> %s (%s)", u, tildePath(a.TargetFile(p)), p.TargetVersion())
+	if !a.checkURLs {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
 		return err
 	}
-	if p.locateBinaryFile, err = a.LocateBinaryFile(p); err != nil {
+	res, err := a.client.Do(req)
+	if err != nil {
 		return err
 	}
-	a.Log(p, "\033[1;32minstalled %s %s\033[m", p.locateBinaryFile, p.Version.latest)
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s, %s", res.Status, u)
+	}
+	a.Log(p, "%s, %s", res.Status, u)
 	return nil
 }
 
-func run(file string) error {
-	a, err := NewApp()
-	if err != nil {
+// tildePath abbreviates $HOME in file to ~.
+func tildePath(file string) string {
+	home, err := os.UserHomeDir()
+	if err == nil && strings.HasPrefix(file, home+string(filepath.Separator)) {
+		return "~" + file[len(home):]
+	}
+	return file
+}
+
+// Run resolves and installs p within a.ctx.
+func (a *App) Run(p *Package) error {
+	ctx := a.ctx
+	needInstall, err := a.Resolve(ctx, p)
+	if err != nil || !needInstall {
 		return err
 	}
-	defer a.Cleanup()
+	if a.dryRun {
+		return a.DryRun(ctx, p)
+	}
+	return a.Install(ctx, p)
+}
+
+const (
+	defaultConnectTimeout  = 30 * time.Second
+	defaultDownloadTimeout = 30 * time.Second
+	defaultHeadTimeout     = 5 * time.Second
+)
+
+// defaultOptions is for subcommands that make an App without parsing the main flags.
+func defaultOptions() *options {
+	return &options{
+		connectTimeout:  defaultConnectTimeout,
+		downloadTimeout: defaultDownloadTimeout,
+		headTimeout:     defaultHeadTimeout,
+	}
+}
+
+// readOnlyOptions are defaultOptions for subcommands that install nothing,
+// which neither create nor probe the bin dir.
+func readOnlyOptions() *options {
+	opts := defaultOptions()
+	opts.readOnly = true
+	return opts
+}
+
+type options struct {
+	showVersion         versionFlag
+	pinName             string
+	force               bool
+	ifMissing           bool
+	compareRemote       bool
+	onlyIfChanged       bool
+	only                []string
+	exclude             []string
+	reportOutdated      bool
+	tmpDir              string
+	connectTimeout      time.Duration
+	downloadTimeout     time.Duration
+	headTimeout         time.Duration
+	extractTimeout      time.Duration
+	configDir           string
+	jobs                string
+	binDir              string
+	manifest            string
+	manifestKey         string
+	manifestSHA256      string
+	caCert              string
+	locked              bool
+	retries             int
+	maxIdleConnsPerHost int
+	summaryJSON         string
+	dryRun              bool
+	readOnly            bool
+	noColor             bool
+	skipVersionCheck    bool
+	noCache             bool
+	output              string
+	failOnUnsupported   bool
+	tolerateVersionErrs bool
+	waitLock            bool
+	checkURLs           bool
+}
+
+func findPackage(packages []*Package, name string) (*Package, error) {
+	for _, p := range packages {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown package %s", name)
+}
 
-	packages, err := loadYAML(file)
+func verifyAssetCommand(args []string) error {
+	fs := flag.NewFlagSet("verify-asset", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download verify-asset [-config-dir dir] [packages.yml...] name file")
+		fs.PrintDefaults()
+	}
+	configDir := fs.String("config-dir", "", configDirUsage)
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errReported
+	}
+	args = fs.Args()
+	files, name, file := args[:len(args)-2], args[len(args)-2], args[len(args)-1]
+	config, err := loadConfigOrDefault(files, *configDir)
+	if err != nil {
+		return err
+	}
+	p, err := findPackage(config.Packages, name)
+	if err != nil {
+		return err
+	}
+	a, err := NewApp(readOnlyOptions())
 	if err != nil {
 		return err
 	}
+	defer a.Cleanup()
+	if err := a.VerifyFile(a.ctx, p, file); err != nil {
+		fmt.Printf("fail: %s, %s\n", file, err)
+		return errReported
+	}
+	fmt.Printf("pass: %s\n", file)
+	return nil
+}
+
+// filterPackages returns the packages named in only, or all if only is empty,
+// without those named in exclude. Unknown names in either are errors.
+func filterPackages(packages []*Package, only, exclude []string) ([]*Package, error) {
+	filtered := packages
+	if len(only) > 0 {
+		filtered = nil
+		for _, name := range only {
+			p, err := findPackage(packages, name)
+			if err != nil {
+				return nil, err
+			}
+			filtered = append(filtered, p)
+		}
+	}
+	if len(exclude) == 0 {
+		return filtered, nil
+	}
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		p, err := findPackage(packages, name)
+		if err != nil {
+			return nil, err
+		}
+		excluded[p.Name] = true
+	}
+	var rest []*Package
+	for _, p := range filtered {
+		if !excluded[p.Name] {
+			rest = append(rest, p)
+		}
+	}
+	return rest, nil
+}
+
+const (
+	resolveJobs = 10
+	installJobs = 3
+	maxAutoJobs = 8
+)
+
+// parseJobs parses -jobs, a positive number or auto.
+// Installs are bound by the network more than by CPUs while extraction is not,
+// so auto runs 2 jobs per CPU, at least installJobs and at most maxAutoJobs.
+func parseJobs(s string) (int, error) {
+	if s == "auto" {
+		jobs := 2 * runtime.NumCPU()
+		if jobs < installJobs {
+			jobs = installJobs
+		}
+		if jobs > maxAutoJobs {
+			jobs = maxAutoJobs
+		}
+		return jobs, nil
+	}
+	jobs, err := strconv.Atoi(s)
+	if err != nil || jobs < 1 {
+		return 0, fmt.Errorf("-jobs must be a positive number or auto, but %q", s)
+	}
+	return jobs, nil
+}
 
-	failChan := make(chan string)
-	var fails []string
+// parallel calls f for each package with at most jobs goroutines,
+// and returns the names of packages for which f failed,
+// and of packages that were aborted or not started because of an interrupt.
+func (a *App) parallel(packages []*Package, jobs int, f func(p *Package) error) ([]string, []string) {
+	failChan := make(chan *Package)
+	var fails, cancelled []string
+	collected := make(chan struct{})
 	go func() {
-		for fail := range failChan {
-			fails = append(fails, fail)
+		for p := range failChan {
+			if errors.Is(p.err, context.Canceled) {
+				cancelled = append(cancelled, p.Name)
+			} else {
+				fails = append(fails, p.Name)
+			}
 		}
+		close(collected)
 	}()
 
-	limit := make(chan struct{}, 3)
-	for i := 0; i < 3; i++ {
-		limit <- struct{}{}
-	}
+	// sem holds a token for each running goroutine
+	sem := make(chan struct{}, jobs)
 	var wg sync.WaitGroup
 
-	for _, p := range packages {
-		<-limit
+	// with one job at a time, lines of different packages cannot interleave, so they are not held
+	hold := jobs > 1 && len(packages) > 1
+	var notStarted []string
+	for i, p := range packages {
+		select {
+		case sem <- struct{}{}:
+		case <-a.ctx.Done():
+		}
+		if a.isInterrupted() {
+			for _, p := range packages[i:] {
+				notStarted = append(notStarted, p.Name)
+			}
+			break
+		}
+		if hold {
+			p.logs = &bytes.Buffer{}
+		}
 		wg.Add(1)
 		go func(p *Package) {
 			defer func() {
+				a.flushLog(p)
+				<-sem
 				wg.Done()
-				limit <- struct{}{}
 			}()
-			if err := a.Run(p); err != nil {
-				a.Log(p, "failed, %s", err.Error())
-				failChan <- p.Name
+			if err := f(p); err != nil {
+				p.err = err
+				if errors.Is(err, context.Canceled) {
+					a.Log(p, "cancelled")
+				} else {
+					a.Log(p, "failed, %s", err.Error())
+				}
+				failChan <- p
 			}
 		}(p)
 	}
 	wg.Wait()
 	close(failChan)
+	<-collected
+	return fails, append(cancelled, notStarted...)
+}
+
+func run(files []string, opts *options) error {
+	jobs, err := parseJobs(opts.jobs)
+	if err != nil {
+		return err
+	}
+	if opts.output != outputText && opts.output != outputJSON {
+		return fmt.Errorf("-output must be text or json, but %q", opts.output)
+	}
+	if opts.force && opts.ifMissing {
+		return errors.New("-force cannot be used with -if-missing")
+	}
+	if opts.locked && opts.pinName != "" {
+		return errors.New("-locked cannot be used with -version")
+	}
+	if opts.locked && opts.manifest != "" {
+		return errors.New("-locked cannot be used with -manifest")
+	}
+	if opts.downloadTimeout < 0 || opts.headTimeout < 0 {
+		return errors.New("-timeout-download and -timeout-head must not be negative")
+	}
+	if opts.retries < 1 {
+		return fmt.Errorf("-retries must be at least 1, but %d", opts.retries)
+	}
+	unlock, err := lock(opts.waitLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	a, err := NewApp(opts)
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+	defer a.handleInterrupt()()
+	ctx := a.ctx
+
+	source := strings.Join(files, " ")
+	if opts.manifest != "" {
+		if len(files) > 0 {
+			return fmt.Errorf("-manifest cannot be used with %s", source)
+		}
+		source = "-manifest " + opts.manifest + " -manifest-key " + opts.manifestKey
+		file, err := a.fetchManifest(ctx, opts.manifest, opts.manifestKey, opts.manifestSHA256)
+		if err != nil {
+			return err
+		}
+		files = []string{file}
+	}
+	config, err := loadConfig(files, opts.configDir)
+	if err != nil {
+		return err
+	}
+	only := opts.only
+	if opts.pinName != "" {
+		p, err := findPackage(config.Packages, opts.pinName)
+		if err != nil {
+			return err
+		}
+		p.Version.Fixed = opts.showVersion.value
+		only = []string{p.Name}
+	}
+	packages, err := filterPackages(config.Packages, only, opts.exclude)
+	if err != nil {
+		return err
+	}
+	if opts.manifest == "" {
+		if a.lockfile, err = loadLockfile(lockfilePath(files, opts.configDir)); err != nil {
+			return err
+		}
+		if opts.locked {
+			if err := a.lockfile.lock(packages); err != nil {
+				return err
+			}
+		}
+	}
+	if !inPath(a.binDir) {
+		fmt.Fprintf(os.Stderr, "warning: %s is not in PATH\n", a.binDir)
+	}
+
+	if len(config.BeforeAll) > 0 {
+		if err := runHook(config.BeforeAll); err != nil {
+			return fmt.Errorf("before_all failed, %w", err)
+		}
+	}
+
+	fails, cancelled := a.parallel(packages, resolveJobs, func(p *Package) (err error) {
+		p.needInstall, err = a.Resolve(ctx, p)
+		return err
+	})
+	var outdated []*Package
+	for _, p := range packages {
+		if p.needInstall {
+			outdated = append(outdated, p)
+		}
+	}
+	install := func(p *Package) error { return a.Install(ctx, p) }
+	if a.dryRun {
+		install = func(p *Package) error { return a.DryRun(ctx, p) }
+	}
+	var installFails []string
+	if !opts.reportOutdated {
+		var installCancelled []string
+		installFails, installCancelled = a.parallel(outdated, jobs, install)
+		fails = append(fails, installFails...)
+		cancelled = append(cancelled, installCancelled...)
+	}
+
+	if len(config.AfterAll) > 0 {
+		if err := runHook(config.AfterAll, fmt.Sprintf("DOWNLOAD_FAILURES=%d", len(fails))); err != nil {
+			if len(fails) == 0 {
+				return fmt.Errorf("after_all failed, %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "after_all failed, %s\n", err)
+		}
+	}
+	if opts.output == outputJSON {
+		if err := writeJSONOutput(os.Stdout, a, packages, cancelled, opts.reportOutdated); err != nil {
+			return err
+		}
+	}
+	if opts.reportOutdated {
+		if opts.output == outputJSON {
+			return nil
+		}
+		if opts.configDir != "" {
+			source = strings.TrimSpace("-config-dir " + opts.configDir + " " + source)
+		}
+		reportOutdated(source, outdated, fails)
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, a.stats.String())
+	if len(cancelled) > 0 {
+		fmt.Fprintf(os.Stderr, "cancelled %s\n", strings.Join(cancelled, ", "))
+	}
+	if !opts.dryRun {
+		if err := a.state.Save(); err != nil {
+			return err
+		}
+		if a.lockfile != nil {
+			if err := a.lockfile.Save(); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.summaryJSON != "" {
+		if err := writeSummaryJSON(opts.summaryJSON, outdated, installFails, fails, cancelled, &a.stats); err != nil {
+			return err
+		}
+	}
 	if len(fails) == 0 {
+		if len(cancelled) > 0 {
+			return errors.New("interrupted")
+		}
 		return nil
 	}
 	return fmt.Errorf("failed to install %s", strings.Join(fails, ", "))
 }
 
+type summary struct {
+	Installed []string `json:"installed"`
+	Failed    []string `json:"failed"`
+	Cancelled []string `json:"cancelled"`
+	Stats     *Stats   `json:"stats"`
+}
+
+func writeSummaryJSON(file string, outdated []*Package, installFails, fails, cancelled []string, stats *Stats) error {
+	failed := map[string]bool{}
+	for _, name := range append(installFails, cancelled...) {
+		failed[name] = true
+	}
+	s := summary{Installed: []string{}, Failed: fails, Cancelled: cancelled, Stats: stats}
+	for _, p := range outdated {
+		if !failed[p.Name] {
+			s.Installed = append(s.Installed, p.Name)
+		}
+	}
+	if s.Failed == nil {
+		s.Failed = []string{}
+	}
+	if s.Cancelled == nil {
+		s.Cancelled = []string{}
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(b, '\n'), 0644)
+}
+
+func reportOutdated(file string, outdated []*Package, fails []string) {
+	if len(fails) > 0 {
+		fmt.Printf("failed to check %s\n", strings.Join(fails, ", "))
+	}
+	if len(outdated) == 0 {
+		if len(fails) == 0 {
+			fmt.Println("all packages are up to date")
+		}
+		return
+	}
+	var names []string
+	for _, p := range outdated {
+		names = append(names, p.Name)
+	}
+	fmt.Printf("outdated packages: %s\n", strings.Join(names, ", "))
+	fmt.Printf("to upgrade them, run:\n  download -only %s -force %s\n", strings.Join(names, ","), file)
+}
+
+// parseArgs parses flags that may appear before or after positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 var version = "dev"
 
+// errReported is returned by subcommands that have already reported the problem.
+var errReported = errors.New("reported")
+
+var subcommands map[string]func(args []string) error
+
+func init() {
+	subcommands = map[string]func(args []string) error{
+		"add":          addCommand,
+		"clear-cache":  clearCacheCommand,
+		"completion":   completionCommand,
+		"doctor":       doctorCommand,
+		"init":         initCommand,
+		"list":         listCommand,
+		"print-config": printConfigCommand,
+		"prune-cache":  pruneCacheCommand,
+		"uninstall":    uninstallCommand,
+		"verify-asset": verifyAssetCommand,
+	}
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = strings.Split(s, ",")
+	return nil
+}
+
+// versionFlag is -version, which shows the version of go-download alone,
+// and pins the version of a package as -version VERSION NAME [packages.yml...].
+type versionFlag struct {
+	set   bool
+	value string
+}
+
+func (v *versionFlag) String() string {
+	return v.value
+}
+
+func (v *versionFlag) Set(s string) error {
+	v.set = true
+	if s != "true" {
+		v.value = s
+	}
+	return nil
+}
+
+func (v *versionFlag) IsBoolFlag() bool {
+	return true
+}
+
+func newFlagSet(opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download [options] [packages.yml...]")
+		fmt.Println("       download [options] -config-dir dir [packages.yml]")
+		fmt.Println("       download [options] -manifest url -manifest-key key")
+		fmt.Println("       download [options] -version VERSION NAME [packages.yml...]")
+		fmt.Println("       download add [-version-command command] github-url packages.yml")
+		fmt.Println("       download clear-cache")
+		fmt.Println("       download completion [-config-dir dir] bash|zsh|fish [packages.yml...]")
+		fmt.Println("       download doctor [-bin-dir dir]")
+		fmt.Println("       download init [-force] [github-url]")
+		fmt.Println("       download list [-exit-code] [-config-dir dir] [packages.yml...]")
+		fmt.Println("       download print-config [options] [packages.yml...]")
+		fmt.Println("       download prune-cache [options]")
+		fmt.Println("       download uninstall [-bin-dir dir] [-config-dir dir] name [packages.yml...]")
+		fmt.Println("       download verify-asset [-config-dir dir] [packages.yml...] name file")
+		fs.PrintDefaults()
+	}
+	fs.Var(&opts.showVersion, "version", "show version, or with `VERSION` NAME install the package NAME at VERSION")
+	fs.BoolVar(&opts.force, "force", false, "install packages even if they already have the latest version")
+	fs.BoolVar(&opts.ifMissing, "if-missing", false, "install only packages whose binaries are missing from the bin dir, regardless of versions")
+	fs.Var((*stringList)(&opts.only), "only", "comma separated package names to process")
+	fs.Var((*stringList)(&opts.exclude), "exclude", "comma separated package names to skip")
+	fs.BoolVar(&opts.compareRemote, "compare-remote", false, "compare the downloaded binary with the installed one")
+	fs.BoolVar(&opts.onlyIfChanged, "only-if-changed", false, "skip installing binaries identical to the installed ones, implies -compare-remote")
+	fs.DurationVar(&opts.connectTimeout, "timeout-connect", defaultConnectTimeout, "timeout for establishing connections")
+	fs.DurationVar(&opts.downloadTimeout, "timeout-download", defaultDownloadTimeout, "timeout for each HTTP request including reading the body, 0 means no timeout")
+	fs.DurationVar(&opts.headTimeout, "timeout-head", defaultHeadTimeout, "timeout for resolving latest versions from redirects, 0 means no timeout")
+	fs.DurationVar(&opts.downloadTimeout, "download-timeout", defaultDownloadTimeout, "alias of -timeout-download")
+	fs.DurationVar(&opts.headTimeout, "head-timeout", defaultHeadTimeout, "alias of -timeout-head")
+	fs.DurationVar(&opts.extractTimeout, "timeout-extract", 10*time.Minute, "timeout for extracting an archive, 0 means no timeout")
+	fs.IntVar(&opts.maxIdleConnsPerHost, "max-idle-conns-per-host", 10, "maximum idle keep-alive connections kept per host")
+	fs.IntVar(&opts.retries, "retries", defaultRetries, "number of attempts for HTTP requests on errors, 429 and 5XX")
+	fs.StringVar(&opts.jobs, "jobs", strconv.Itoa(installJobs), "number of packages installed in parallel, or auto to scale with CPUs")
+	fs.StringVar(&opts.binDir, "bin-dir", "", "directory to install binaries to, default $HOME/bin, or %LOCALAPPDATA%\\go-download\\bin on Windows")
+	fs.StringVar(&opts.manifest, "manifest", "", "use the signed config at this URL, its signature is at the URL plus .sig")
+	fs.StringVar(&opts.manifestKey, "manifest-key", "", "base64 ed25519 public key to verify -manifest with")
+	fs.StringVar(&opts.manifestSHA256, "manifest-sha256", "", "expected sha256 of -manifest")
+	fs.StringVar(&opts.caCert, "ca-cert", "", "PEM `file` of extra root CAs to trust, default $DOWNLOAD_CA_CERT")
+	fs.BoolVar(&opts.locked, "locked", false, "install the versions recorded in packages.lock next to the config instead of the latest ones")
+	fs.StringVar(&opts.configDir, "config-dir", "", configDirUsage)
+	fs.StringVar(&opts.tmpDir, "tmp", "", "directory to create the temporary work directory in, default $TMPDIR")
+	fs.StringVar(&opts.summaryJSON, "summary-json", "", "write a JSON summary of the run to `file`")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "show what would be installed without installing")
+	fs.BoolVar(&opts.skipVersionCheck, "skip-version-check", false, "do not run version.command with installed binaries to confirm their versions")
+	fs.StringVar(&opts.output, "output", outputText, "output format, text, or json to print one JSON object per package and a summary to stdout")
+	fs.BoolVar(&opts.noCache, "no-cache", false, "neither use nor save cached downloads")
+	fs.BoolVar(&opts.noColor, "no-color", false, "disable colored output, also disabled by NO_COLOR or when stderr is not a terminal")
+	fs.BoolVar(&opts.checkURLs, "check-urls", false, "with -dry-run, check that download URLs exist with HEAD requests")
+	fs.BoolVar(&opts.failOnUnsupported, "fail-on-unsupported", false, "treat packages without download_url for this OS as failures instead of skipping them")
+	fs.BoolVar(&opts.tolerateVersionErrs, "tolerate-version-errors", false, "keep the installed version if the latest version lookup fails")
+	fs.BoolVar(&opts.waitLock, "wait-lock", false, "wait for another running go-download instead of failing")
+	fs.BoolVar(&opts.reportOutdated, "report-outdated", false, "report outdated packages and how to upgrade them, without installing")
+	return fs
+}
+
+// pinArgs takes VERSION and NAME from args given with -version,
+// or reports that -version alone asks for the version of go-download.
+func pinArgs(opts *options, args []string) ([]string, bool, error) {
+	v := &opts.showVersion
+	if !v.set {
+		return args, false, nil
+	}
+	if v.value == "" && len(args) >= 2 {
+		v.value, args = args[0], args[1:]
+	}
+	if v.value == "" {
+		return args, true, nil
+	}
+	if len(args) == 0 {
+		return nil, false, fmt.Errorf("Usage: download -version VERSION NAME [packages.yml...]")
+	}
+	opts.pinName = args[0]
+	return args[1:], false, nil
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("too few arguments")
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				if err != errReported {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	opts := &options{}
+	fs := newFlagSet(opts)
+	args, err := parseArgs(fs, os.Args[1:])
+	if err != nil {
 		os.Exit(1)
 	}
-	if os.Args[1] == "-h" || os.Args[1] == "--help" {
-		fmt.Println("Usage: download packages.yml")
+	args, showVersion, err := pinArgs(opts, args)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	if os.Args[1] == "--version" {
+	if showVersion {
 		fmt.Println(version)
 		os.Exit(0)
 	}
-	if err := run(os.Args[1]); err != nil {
+	if len(args) == 0 && opts.configDir == "" && opts.manifest == "" {
+		file, err := defaultConfigFile()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		args = []string{file}
+	}
+	if err := run(args, opts); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
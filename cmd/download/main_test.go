@@ -0,0 +1,1259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// setenv sets the environment variable name to value until the end of the test,
+// an empty value unsets it.
+func setenv(t *testing.T, name, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(name)
+	if value == "" {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, value)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+// tempDir returns a directory removed at the end of the test.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "download-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// isolateHome points HOME and the XDG directories to a temporary directory
+// and hides credentials of the environment, returning the new HOME.
+func isolateHome(t *testing.T) string {
+	t.Helper()
+	home := tempDir(t)
+	setenv(t, "HOME", home)
+	setenv(t, "USERPROFILE", home)
+	setenv(t, "XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	setenv(t, "XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	setenv(t, "XDG_STATE_HOME", filepath.Join(home, ".local", "state"))
+	setenv(t, "NETRC", filepath.Join(home, ".netrc"))
+	setenv(t, "GITHUB_TOKEN", "")
+	setenv(t, "GH_TOKEN", "")
+	setenv(t, "DOWNLOAD_CA_CERT", "")
+	return home
+}
+
+// newTestApp makes an App with the default options in an isolated HOME.
+func newTestApp(t *testing.T, modify ...func(*options)) *App {
+	t.Helper()
+	return newTestAppIn(t, isolateHome(t), modify...)
+}
+
+// newTestAppIn is newTestApp in home already isolated by isolateHome.
+func newTestAppIn(t *testing.T, home string, modify ...func(*options)) *App {
+	t.Helper()
+	opts := defaultOptions()
+	opts.binDir = filepath.Join(home, "bin")
+	opts.retries = 1
+	for _, f := range modify {
+		f(opts)
+	}
+	a, err := NewApp(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Cleanup)
+	return a
+}
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestPinArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		argv        []string
+		args        []string
+		version     string
+		pin         string
+		showVersion bool
+		err         bool
+	}{
+		{name: "no -version", argv: []string{"a.yml"}, args: []string{"a.yml"}},
+		{name: "show version", argv: []string{"-version"}, showVersion: true},
+		{name: "show version with a file", argv: []string{"-version", "a.yml"}, args: []string{"a.yml"}, showVersion: true},
+		{name: "pin with default config", argv: []string{"-version", "v1.4.0", "tool"}, version: "v1.4.0", pin: "tool"},
+		{name: "pin with a file", argv: []string{"-version", "v1.4.0", "tool", "a.yml"}, args: []string{"a.yml"}, version: "v1.4.0", pin: "tool"},
+		{name: "pin with files", argv: []string{"-version", "v1.4.0", "tool", "a.yml", "b.yml"}, args: []string{"a.yml", "b.yml"}, version: "v1.4.0", pin: "tool"},
+		{name: "pin with =", argv: []string{"-version=v1.4.0", "tool"}, version: "v1.4.0", pin: "tool"},
+		{name: "pin after other flags", argv: []string{"-dry-run", "-version", "v1.4.0", "tool", "-jobs", "2", "a.yml"}, args: []string{"a.yml"}, version: "v1.4.0", pin: "tool"},
+		{name: "pin without name", argv: []string{"-version=v1.4.0"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &options{}
+			args, err := parseArgs(newFlagSet(opts), tt.argv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args, showVersion, err := pinArgs(opts, args)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expect an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(args) == 0 {
+				args = nil
+			}
+			if !reflect.DeepEqual(args, tt.args) || showVersion != tt.showVersion {
+				t.Errorf("expect %q and %v, but %q and %v", tt.args, tt.showVersion, args, showVersion)
+			}
+			if opts.showVersion.value != tt.version || opts.pinName != tt.pin {
+				t.Errorf("expect pin %s@%s, but %s@%s", tt.pin, tt.version, opts.pinName, opts.showVersion.value)
+			}
+		})
+	}
+}
+
+// captureStderr returns what f writes to os.Stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stderr, f)
+}
+
+// captureStdout returns what f writes to os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+func capture(t *testing.T, out **os.File, f func()) string {
+	t.Helper()
+	file, err := ioutil.TempFile("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	saved := *out
+	*out = file
+	defer func() { *out = saved }()
+	f()
+	b, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// chdir changes the working directory until the end of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestDefaultConfigFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		exist  []string
+		expect string
+	}{
+		{name: "current dir first", exist: []string{"cwd", "xdg", "home"}, expect: "cwd"},
+		{name: "XDG_CONFIG_HOME before ~/.config", exist: []string{"xdg", "home"}, expect: "xdg"},
+		{name: "~/.config", exist: []string{"home"}, expect: "home"},
+		{name: "none", expect: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			setenv(t, "XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+			cwd := filepath.Join(home, "work")
+			paths := map[string]string{
+				"cwd":  filepath.Join(cwd, "packages.yml"),
+				"xdg":  filepath.Join(home, "xdg", "go-download", "packages.yml"),
+				"home": filepath.Join(home, ".config", "go-download", "packages.yml"),
+			}
+			if err := os.MkdirAll(cwd, 0777); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.exist {
+				writeFile(t, filepath.Dir(paths[name]), "packages.yml", "packages: []\n")
+			}
+			chdir(t, cwd)
+			file, err := defaultConfigFile()
+			if tt.expect == "" {
+				if err == nil {
+					t.Fatalf("expect an error, but %s", file)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := paths[tt.expect]
+			if tt.expect == "cwd" {
+				expect = "packages.yml"
+			}
+			if file != expect {
+				t.Errorf("expect %s, but %s", expect, file)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	pkg := func(name, fixed string) string {
+		return fmt.Sprintf("  - name: %s\n    download_url:\n      linux: https://example.com/%s\n    version:\n      fixed: %s\n", name, name, fixed)
+	}
+	tests := []struct {
+		name   string
+		files  []string
+		dir    []string
+		expect string
+		err    string
+	}{
+		{name: "one file", files: []string{pkg("a", "v1") + pkg("b", "v1")}, expect: "a@v1 b@v1"},
+		{name: "later file overrides in place", files: []string{pkg("a", "v1") + pkg("b", "v1"), pkg("a", "v2") + pkg("c", "v1")}, expect: "a@v2 b@v1 c@v1"},
+		{name: "dir after files", files: []string{pkg("a", "v1")}, dir: []string{pkg("b", "v1"), pkg("c", "v1")}, expect: "a@v1 b@v1 c@v1"},
+		{name: "dir redefines a file", files: []string{pkg("a", "v1")}, dir: []string{pkg("a", "v2")}, err: "package a is already defined"},
+		{name: "dir redefines itself", dir: []string{pkg("a", "v1"), pkg("a", "v2")}, err: "package a is already defined"},
+		{name: "empty dir", dir: []string{}, err: "no *.yml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := tempDir(t)
+			var files []string
+			for i, c := range tt.files {
+				files = append(files, writeFile(t, tmp, fmt.Sprintf("%d.yml", i), "packages:\n"+c))
+			}
+			dir := ""
+			if tt.dir != nil {
+				dir = filepath.Join(tmp, "conf.d")
+				os.Mkdir(dir, 0777)
+				for i, c := range tt.dir {
+					writeFile(t, dir, fmt.Sprintf("%d.yml", i), "packages:\n"+c)
+				}
+			}
+			config, err := loadConfig(files, dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range config.Packages {
+				got = append(got, p.Name+"@"+p.Version.Fixed)
+			}
+			if strings.Join(got, " ") != tt.expect {
+				t.Errorf("expect %s, but %s", tt.expect, strings.Join(got, " "))
+			}
+		})
+	}
+}
+
+func TestLoadConfigOrDefault(t *testing.T) {
+	home := isolateHome(t)
+	writeFile(t, filepath.Join(home, ".config", "go-download"), "packages.yml",
+		"packages:\n  - name: a\n    download_url:\n      linux: https://example.com/a\n    version:\n      fixed: v1\n")
+	chdir(t, home)
+	config, err := loadConfigOrDefault(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Packages) != 1 || config.Packages[0].Name != "a" {
+		t.Fatalf("expect package a from the default config, but %v", config.Packages)
+	}
+}
+
+func TestReadOnlySubcommands(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(config, file string) error
+	}{
+		{name: "list", run: func(config, file string) error { return listCommand([]string{config}) }},
+		{name: "print-config", run: func(config, file string) error { return printConfigCommand([]string{config}) }},
+		{name: "verify-asset", run: func(config, file string) error { return verifyAssetCommand([]string{config, "tool", file}) }},
+		{name: "completion", run: func(config, file string) error { return completionCommand([]string{"bash", config}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			config := writeFile(t, home, "packages.yml",
+				"packages:\n  - name: tool\n    download_url:\n      linux: https://example.com/tool\n    version:\n      fixed: v1.0.0\n")
+			file := writeFile(t, home, "tool", "binary")
+			var err error
+			captureStdout(t, func() { err = tt.run(config, file) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(home, "bin")); !os.IsNotExist(err) {
+				t.Errorf("expect no bin dir, but %v", err)
+			}
+		})
+	}
+}
+
+func TestTimeoutFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		argv     []string
+		download time.Duration
+		head     time.Duration
+	}{
+		{name: "default", download: defaultDownloadTimeout, head: defaultHeadTimeout},
+		{name: "timeout-download", argv: []string{"-timeout-download", "3m"}, download: 3 * time.Minute, head: defaultHeadTimeout},
+		{name: "download-timeout", argv: []string{"-download-timeout", "3m"}, download: 3 * time.Minute, head: defaultHeadTimeout},
+		{name: "timeout-head", argv: []string{"-timeout-head", "5s"}, download: defaultDownloadTimeout, head: 5 * time.Second},
+		{name: "head-timeout", argv: []string{"-head-timeout", "5s"}, download: defaultDownloadTimeout, head: 5 * time.Second},
+		{name: "no timeout", argv: []string{"-download-timeout", "0", "-head-timeout", "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &options{}
+			if _, err := parseArgs(newFlagSet(opts), tt.argv); err != nil {
+				t.Fatal(err)
+			}
+			a := newTestApp(t, func(o *options) {
+				o.downloadTimeout, o.headTimeout = opts.downloadTimeout, opts.headTimeout
+			})
+			if a.client.Timeout != tt.download {
+				t.Errorf("expect the download timeout %s, but %s", tt.download, a.client.Timeout)
+			}
+			if a.noRedirectClient.Timeout != tt.head {
+				t.Errorf("expect the head timeout %s, but %s", tt.head, a.noRedirectClient.Timeout)
+			}
+		})
+	}
+}
+
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		name      string
+		jobs      int
+		packages  int
+		interrupt int
+	}{
+		{name: "one job", jobs: 1, packages: 10},
+		{name: "two jobs", jobs: 2, packages: 10},
+		{name: "more jobs than packages", jobs: 16, packages: 10},
+		{name: "one package", jobs: 4, packages: 1},
+		{name: "interrupted", jobs: 1, packages: 10, interrupt: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t)
+			var packages []*Package
+			for i := 0; i < tt.packages; i++ {
+				packages = append(packages, &Package{Name: fmt.Sprintf("p%d", i)})
+			}
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+			attempted := map[string]bool{}
+			var fails, cancelled []string
+			stderr := captureStderr(t, func() {
+				fails, cancelled = a.parallel(packages, tt.jobs, func(p *Package) error {
+					mu.Lock()
+					attempted[p.Name] = true
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					mu.Unlock()
+					defer func() {
+						mu.Lock()
+						running--
+						mu.Unlock()
+					}()
+					a.Log(p, "start")
+					time.Sleep(time.Millisecond)
+					a.Log(p, "end")
+					if tt.interrupt > 0 && p.Name == fmt.Sprintf("p%d", tt.interrupt) {
+						a.interrupt()
+					}
+					switch i := strings.TrimPrefix(p.Name, "p"); {
+					case i == "3":
+						return context.Canceled
+					case i == "1" || i == "5" || i == "7":
+						return fmt.Errorf("%s failed", p.Name)
+					}
+					return nil
+				})
+			})
+			if maxRunning > tt.jobs {
+				t.Errorf("expect at most %d running, but %d", tt.jobs, maxRunning)
+			}
+			var expectFails, expectCancelled []string
+			for i := 0; i < tt.packages; i++ {
+				name := fmt.Sprintf("p%d", i)
+				if started := tt.interrupt == 0 || i <= tt.interrupt; attempted[name] != started {
+					t.Errorf("expect %s attempted %v, but %v", name, started, attempted[name])
+				}
+				switch {
+				case tt.interrupt > 0 && i > tt.interrupt:
+					expectCancelled = append(expectCancelled, name)
+				case i == 3:
+					expectCancelled = append([]string{name}, expectCancelled...)
+				case i == 1 || i == 5 || i == 7:
+					expectFails = append(expectFails, name)
+				}
+			}
+			sort.Strings(fails)
+			if fmt.Sprint(fails) != fmt.Sprint(expectFails) {
+				t.Errorf("expect fails %v, but %v", expectFails, fails)
+			}
+			if fmt.Sprint(cancelled) != fmt.Sprint(expectCancelled) {
+				t.Errorf("expect cancelled %v, but %v", expectCancelled, cancelled)
+			}
+			// held lines of a package are written together
+			lines := strings.Split(strings.TrimSpace(stderr), "\n")
+			for i, line := range lines {
+				if strings.HasSuffix(line, ": start") && tt.jobs > 1 && (i+1 >= len(lines) || lines[i+1] != strings.TrimSuffix(line, "start")+"end") {
+					t.Errorf("expect the lines of a package together, but %q", lines)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseJobs(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect int
+		err    bool
+	}{
+		{in: "1", expect: 1},
+		{in: "3", expect: 3},
+		{in: "64", expect: 64},
+		{in: "0", err: true},
+		{in: "-1", err: true},
+		{in: "many", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			jobs, err := parseJobs(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expect an error, but %d", jobs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jobs != tt.expect {
+				t.Errorf("expect %d, but %d", tt.expect, jobs)
+			}
+		})
+	}
+	jobs, err := parseJobs("auto")
+	if err != nil || jobs < installJobs || jobs > maxAutoJobs {
+		t.Errorf("expect auto in [%d, %d], but %d, %v", installJobs, maxAutoJobs, jobs, err)
+	}
+}
+
+func TestDownloadStatus(t *testing.T) {
+	body := strings.Repeat("<html>error page</html>\n", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tool":
+			io.WriteString(w, "binary")
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, body)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, body)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name string
+		path string
+		err  string
+	}{
+		{name: "ok", path: "/tool"},
+		{name: "not found", path: "/gone", err: "404 Not Found, " + srv.URL + "/gone"},
+		{name: "forbidden", path: "/private", err: "403 Forbidden, " + srv.URL + "/private"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t)
+			p := &Package{Name: "tool", downloadURL: srv.URL + tt.path}
+			file, err := a.Download(context.Background(), p)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b, _ := ioutil.ReadFile(file); string(b) != "binary" {
+					t.Errorf("expect the binary, but %q", b)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expect error %q, but %v", tt.err, err)
+			}
+			if infos, _ := ioutil.ReadDir(filepath.Join(a.workDir, p.Name)); len(infos) > 0 {
+				t.Errorf("expect no file left, but %s", infos[0].Name())
+			}
+			if a.stats.DownloadedBytes != 0 {
+				t.Errorf("expect the body not copied, but %d bytes", a.stats.DownloadedBytes)
+			}
+		})
+	}
+}
+
+func TestDownloadURLFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		os     string
+		arch   string
+		expect string
+	}{
+		{name: "flat on darwin/arm64", config: "mac: https://example.com/tool-mac", os: "darwin", arch: "arm64", expect: "https://example.com/tool-mac"},
+		{name: "flat on linux/amd64", config: "linux: https://example.com/tool-linux", os: "linux", arch: "amd64", expect: "https://example.com/tool-linux"},
+		{name: "nested on darwin/arm64", config: "mac: {amd64: https://example.com/tool-x64, arm64: https://example.com/tool-arm64}", os: "darwin", arch: "arm64", expect: "https://example.com/tool-arm64"},
+		{name: "nested on darwin/amd64", config: "mac: {amd64: https://example.com/tool-x64, arm64: https://example.com/tool-arm64}", os: "darwin", arch: "amd64", expect: "https://example.com/tool-x64"},
+		{name: "nested on linux/amd64", config: "linux: {amd64: https://example.com/tool-x64}", os: "linux", arch: "amd64", expect: "https://example.com/tool-x64"},
+		{name: "nested without the arch", config: "linux: {amd64: https://example.com/tool-x64}", os: "linux", arch: "arm64", expect: ""},
+		{name: "relative to releases", config: "linux: v1.0.0/tool", os: "linux", arch: "amd64", expect: "https://github.com/o/r/releases/download/v1.0.0/tool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Package
+			if err := yaml.Unmarshal([]byte("url: https://github.com/o/r\ndownload_url: {"+strings.TrimSpace(tt.config)+"}"), &p); err != nil {
+				t.Fatal(err)
+			}
+			if supported := p.SupportsOS(tt.os, tt.arch); supported != (tt.expect != "") {
+				t.Fatalf("expect supported %v, but %v", tt.expect != "", supported)
+			}
+			if got := p.DownloadURLFor(tt.os, tt.arch); tt.expect != "" && got != tt.expect {
+				t.Errorf("expect %q, but %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestPrepareBinDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+		err   string
+	}{
+		{name: "missing", setup: func(t *testing.T, dir string) {}},
+		{name: "existing", setup: func(t *testing.T, dir string) {
+			if err := os.Mkdir(dir, 0777); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "file in the way", setup: func(t *testing.T, dir string) {
+			writeFile(t, filepath.Dir(dir), filepath.Base(dir), "not a directory")
+		}, err: "exists, but is not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			dir := filepath.Join(home, "bin")
+			tt.setup(t, dir)
+			opts := defaultOptions()
+			opts.binDir = dir
+			a, err := NewApp(opts)
+			if tt.err != "" {
+				if err == nil {
+					a.Cleanup()
+					t.Fatalf("expect error %q", tt.err)
+				}
+				if !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Cleanup()
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				t.Errorf("expect %s to be a directory, %v", dir, err)
+			}
+		})
+	}
+}
+
+func TestSelectBinary(t *testing.T) {
+	dir := tempDir(t)
+	for name, size := range map[string]int{"tool-1.0/bin/tool": 10, "tool-1.0/bin/helper": 20, "tool-1.0/share/data": 1000, "tool-1.0/README.md": 5000} {
+		file := writeFile(t, dir, name, strings.Repeat("x", size))
+		if err := os.Chmod(file, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		binary string
+		strip  int
+		expect string
+		err    string
+	}{
+		{name: "largest without binary", expect: "tool-1.0/share/data"},
+		{name: "exact path", binary: "tool-1.0/bin/tool", expect: "tool-1.0/bin/tool"},
+		{name: "exact name", binary: "tool", expect: "tool-1.0/bin/tool"},
+		{name: "glob name", binary: "t*", expect: "tool-1.0/bin/tool"},
+		{name: "glob path", binary: "*/bin/h*", expect: "tool-1.0/bin/helper"},
+		{name: "glob path with strip", binary: "bin/h*", strip: 1, expect: "tool-1.0/bin/helper"},
+		{name: "no match", binary: "missing", err: "no file matches binary missing"},
+		{name: "multiple matches", binary: "*/bin/*", err: "multiple files match binary */bin/*: tool-1.0/bin/helper, tool-1.0/bin/tool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{Name: "tool", Binary: tt.binary, StripComponents: tt.strip}
+			got, err := selectBinary(p, dir)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expect := filepath.Join(dir, filepath.FromSlash(tt.expect)); got != expect {
+				t.Errorf("expect %s, but %s", expect, got)
+			}
+		})
+	}
+}
+
+func TestLocateBinaryFileInstallAs(t *testing.T) {
+	tests := []struct {
+		name      string
+		installAs string
+		binDir    string
+		expect    string
+	}{
+		{name: "package name", expect: "bin/golang-migrate"},
+		{name: "install_as", installAs: "migrate", expect: "bin/migrate"},
+		{name: "install_as in bin_dir", installAs: "migrate", binDir: "~/tools", expect: "tools/migrate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			a := newTestAppIn(t, home)
+			p := &Package{Name: "golang-migrate", InstallAs: tt.installAs, BinDir: tt.binDir}
+			p.downloadBinaryFile = writeFile(t, a.workDir, "download/migrate-linux", "binary")
+			var target string
+			stderr := captureStderr(t, func() {
+				var err error
+				if target, err = a.LocateBinaryFile(p); err != nil {
+					t.Fatal(err)
+				}
+				a.Log(p, "installed %s", target)
+			})
+			if expect := filepath.Join(home, filepath.FromSlash(tt.expect)); strings.TrimSuffix(target, ".exe") != expect {
+				t.Errorf("expect %s, but %s", expect, target)
+			}
+			if b, err := ioutil.ReadFile(target); err != nil || string(b) != "binary" {
+				t.Errorf("expect the binary at %s, but %q, %v", target, b, err)
+			}
+			if !strings.HasPrefix(stderr, "golang-migrate: ") {
+				t.Errorf("expect logs by the package name, but %q", stderr)
+			}
+		})
+	}
+}
+
+func TestExpandURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		os      string
+		arch    string
+		osMap   map[string]string
+		archMap map[string]string
+		expect  string
+	}{
+		{name: "version", url: "%v/tool-%n", os: "linux", arch: "amd64", expect: "v1.2.0/tool-1.2.0"},
+		{name: "os and arch", url: "tool_%os_%arch", os: "linux", arch: "amd64", expect: "tool_linux_amd64"},
+		{name: "darwin arm64", url: "tool_%os_%arch", os: "darwin", arch: "arm64", expect: "tool_darwin_arm64"},
+		{name: "arch map", url: "tool-%arch", os: "linux", arch: "amd64", archMap: map[string]string{"amd64": "x86_64", "arm64": "aarch64"}, expect: "tool-x86_64"},
+		{name: "arch map without the arch", url: "tool-%arch", os: "linux", arch: "386", archMap: map[string]string{"amd64": "x86_64"}, expect: "tool-386"},
+		{name: "os map", url: "tool-%os", os: "darwin", arch: "amd64", osMap: map[string]string{"darwin": "macOS"}, expect: "tool-macOS"},
+		{name: "all", url: "%owner/%repo/%v/%repo-%n-%os-%arch.tar.gz", os: "darwin", arch: "arm64", osMap: map[string]string{"darwin": "apple-darwin"}, archMap: map[string]string{"arm64": "aarch64"}, expect: "o/r/v1.2.0/r-1.2.0-apple-darwin-aarch64.tar.gz"},
+		{name: "no placeholders", url: "tool", os: "linux", arch: "amd64", expect: "tool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{Name: "tool", URL: "https://github.com/o/r", OSMap: tt.osMap, ArchMap: tt.archMap}
+			p.Version.latest = "v1.2.0"
+			if got := p.expandURL(tt.url, tt.os, tt.arch); got != tt.expect {
+				t.Errorf("expect %q, but %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	old := retryWait
+	retryWait = time.Millisecond
+	t.Cleanup(func() { retryWait = old })
+	tests := []struct {
+		name     string
+		retries  int
+		retryOn  []int
+		statuses []int
+		attempts int
+		expect   int
+	}{
+		{name: "fails twice then succeeds", retries: 3, statuses: []int{500, 503, 200}, attempts: 3, expect: 200},
+		{name: "429", retries: 3, statuses: []int{429, 200}, attempts: 2, expect: 200},
+		{name: "404 is not retried", retries: 3, statuses: []int{404, 200}, attempts: 1, expect: 404},
+		{name: "gives up after retries", retries: 2, statuses: []int{502, 502, 200}, attempts: 2, expect: 502},
+		{name: "one attempt", retries: 1, statuses: []int{500, 200}, attempts: 1, expect: 500},
+		{name: "retry_on", retries: 3, retryOn: []int{404}, statuses: []int{404, 500, 200}, attempts: 2, expect: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[attempts]
+				attempts++
+				mu.Unlock()
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+			a := newTestApp(t, func(opts *options) { opts.retries = tt.retries })
+			p := &Package{Name: "tool", RetryOn: tt.retryOn}
+			var res *http.Response
+			captureStderr(t, func() {
+				var err error
+				if res, err = a.get(context.Background(), p, srv.URL, nil); err != nil {
+					t.Fatal(err)
+				}
+			})
+			res.Body.Close()
+			if res.StatusCode != tt.expect {
+				t.Errorf("expect %d, but %d", tt.expect, res.StatusCode)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("expect %d attempts, but %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	tests := []struct {
+		name    string
+		noColor string
+		flag    bool
+	}{
+		{name: "not a terminal"},
+		{name: "NO_COLOR", noColor: "1"},
+		{name: "-no-color", flag: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "NO_COLOR", tt.noColor)
+			// captureStderr makes stderr a pipe, so it is not a terminal in any case
+			stderr := captureStderr(t, func() {
+				a := newTestApp(t, func(opts *options) { opts.noColor = tt.flag })
+				if a.color {
+					t.Error("expect colors disabled")
+				}
+				a.Log(&Package{Name: "tool"}, a.green("installed %s"), "tool")
+			})
+			if strings.Contains(stderr, "\033[") {
+				t.Errorf("expect no escape sequences, but %q", stderr)
+			}
+			if stderr != "tool: installed tool\n" {
+				t.Errorf("expect the plain line, but %q", stderr)
+			}
+		})
+	}
+	a := &App{color: true}
+	if got := a.green("installed"); got != "\033[1;32minstalled\033[m" {
+		t.Errorf("expect green with colors, but %q", got)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		call    func(ctx context.Context, a *App) error
+		expect  error
+	}{
+		{name: "download cancelled", call: func(ctx context.Context, a *App) error {
+			_, err := a.Download(ctx, &Package{Name: "tool", downloadURL: srv.URL + "/tool"})
+			return err
+		}, expect: context.Canceled},
+		{name: "download deadline", timeout: 50 * time.Millisecond, call: func(ctx context.Context, a *App) error {
+			_, err := a.Download(ctx, &Package{Name: "tool", downloadURL: srv.URL + "/tool"})
+			return err
+		}, expect: context.DeadlineExceeded},
+		{name: "latest version", timeout: 50 * time.Millisecond, call: func(ctx context.Context, a *App) error {
+			_, err := a.LatestVersion(ctx, &Package{Name: "tool", URL: srv.URL + "/o/r"})
+			return err
+		}},
+		{name: "current version", timeout: 50 * time.Millisecond, call: func(ctx context.Context, a *App) error {
+			p := &Package{Name: "tool", Version: PackageVersion{Command: []string{"sleep", "10"}, formatRegexp: regexp.MustCompile(`(\S+)`)}}
+			_, err := a.CurrentVersion(ctx, p)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t)
+			ctx := cancelled
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(context.Background(), tt.timeout)
+				defer cancel()
+			}
+			done := make(chan error, 1)
+			go func() { done <- tt.call(ctx, a) }()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Fatal("expect an error")
+				}
+				if tt.expect != nil && !errors.Is(err, tt.expect) {
+					t.Errorf("expect %v, but %v", tt.expect, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expect to return promptly")
+			}
+		})
+	}
+}
+
+func TestLargestFile(t *testing.T) {
+	type file struct {
+		size int
+		exec bool
+	}
+	tests := []struct {
+		name   string
+		files  map[string]file
+		expect string
+		err    string
+	}{
+		{name: "big README", files: map[string]file{"tool/README": {5000, false}, "tool/tool": {100, true}}, expect: "tool/tool"},
+		{name: "big executable doc", files: map[string]file{"tool/README.md": {5000, true}, "tool/tool": {100, true}}, expect: "tool/tool"},
+		{name: "executable over data", files: map[string]file{"tool/data.bin": {5000, false}, "tool/tool": {100, true}}, expect: "tool/tool"},
+		{name: "largest executable", files: map[string]file{"tool/helper": {200, true}, "tool/tool": {1000, true}}, expect: "tool/tool"},
+		{name: "no mode bits", files: map[string]file{"tool/LICENSE": {5000, false}, "tool/tool.exe": {100, false}, "tool/small": {10, false}}, expect: "tool/tool.exe"},
+		{name: "only docs", files: map[string]file{"tool/README.md": {5000, false}, "tool/LICENSE": {100, false}}, err: "no file in the archive looks like a binary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			// a directory with many entries may be larger than any file
+			for i := 0; i < 100; i++ {
+				writeFile(t, dir, fmt.Sprintf("tool/share/%d.txt", i), "")
+			}
+			for name, f := range tt.files {
+				file := writeFile(t, dir, name, strings.Repeat("x", f.size))
+				if f.exec {
+					if err := os.Chmod(file, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			got, err := largestFile(dir)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expect error %q, but %v, %s", tt.err, err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expect := filepath.Join(dir, filepath.FromSlash(tt.expect)); got != expect {
+				t.Errorf("expect %s, but %s", expect, got)
+			}
+		})
+	}
+}
+
+func TestForceAndIfMissing(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		io.WriteString(w, "#!/bin/sh\necho tool 1.2.0\n")
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name      string
+		force     bool
+		ifMissing bool
+		installed bool
+		current   string
+		install   bool
+	}{
+		{name: "up to date", installed: true, current: "1.2.0"},
+		{name: "outdated", installed: true, current: "1.1.0", install: true},
+		{name: "force up to date", force: true, installed: true, current: "1.2.0", install: true},
+		{name: "if-missing and present", ifMissing: true, installed: true, current: "1.1.0"},
+		{name: "if-missing and absent", ifMissing: true, current: "1.2.0", install: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			_, packages := loadTestPackages(t, fmt.Sprintf(`
+packages:
+  - name: tool
+    type: script
+    skip_version_check: true
+    download_url:
+      linux: %s/%%v/tool
+      mac: %s/%%v/tool
+      windows: %s/%%v/tool
+    version:
+      latest_command: [sh, -c, echo tool v1.2.0]
+      command: [sh, -c, echo tool %s]
+      format: 'tool (\S+)'
+`, srv.URL, srv.URL, srv.URL, tt.current))
+			p := packages[0]
+			a := newTestAppIn(t, home, func(opts *options) {
+				opts.force, opts.ifMissing = tt.force, tt.ifMissing
+			})
+			if tt.installed {
+				writeFile(t, a.binDir, "tool", "old")
+			}
+			mu.Lock()
+			requests = 0
+			mu.Unlock()
+			ctx := context.Background()
+			captureStderr(t, func() {
+				install, err := a.Resolve(ctx, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if install != tt.install {
+					t.Fatalf("expect install %v, but %v", tt.install, install)
+				}
+				if install {
+					if err := a.Install(ctx, p); err != nil {
+						t.Fatal(err)
+					}
+				}
+			})
+			if tt.install && requests != 1 {
+				t.Errorf("expect a download, but %d requests", requests)
+			}
+			if !tt.install && requests != 0 {
+				t.Errorf("expect no download, but %d requests", requests)
+			}
+			if tt.install {
+				if b, err := ioutil.ReadFile(filepath.Join(a.binDir, "tool")); err != nil || !strings.Contains(string(b), "tool 1.2.0") {
+					t.Errorf("expect the new tool installed, but %q, %v", b, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterPackages(t *testing.T) {
+	packages := []*Package{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	tests := []struct {
+		name   string
+		argv   []string
+		expect []string
+		err    string
+	}{
+		{name: "all", expect: []string{"a", "b", "c", "d"}},
+		{name: "only", argv: []string{"-only", "c,a"}, expect: []string{"c", "a"}},
+		{name: "exclude", argv: []string{"-exclude", "b,d"}, expect: []string{"a", "c"}},
+		{name: "only and exclude", argv: []string{"-only", "a,b,c", "-exclude", "b"}, expect: []string{"a", "c"}},
+		{name: "unknown only", argv: []string{"-only", "a,x"}, err: "unknown package x"},
+		{name: "unknown exclude", argv: []string{"-exclude", "x"}, err: "unknown package x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &options{}
+			if _, err := parseArgs(newFlagSet(opts), tt.argv); err != nil {
+				t.Fatal(err)
+			}
+			filtered, err := filterPackages(packages, opts.only, opts.exclude)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, p := range filtered {
+				names = append(names, p.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expect) {
+				t.Errorf("expect %v, but %v", tt.expect, names)
+			}
+		})
+	}
+}
+
+func TestRunOnly(t *testing.T) {
+	home := isolateHome(t)
+	var config strings.Builder
+	config.WriteString("packages:\n")
+	for _, name := range []string{"a", "b", "c"} {
+		fmt.Fprintf(&config, `
+  - name: %s
+    download_url:
+      linux: https://example.com/%s
+      mac: https://example.com/%s
+      windows: https://example.com/%s.exe
+    version:
+      latest_command: [sh, -c, echo %s v1.0.0]
+      command: [sh, -c, echo %s 0.9.0]
+      format: '%s (\S+)'
+`, name, name, name, name, name, name, name)
+	}
+	file := writeFile(t, home, "packages.yml", config.String())
+	opts := &options{}
+	args, err := parseArgs(newFlagSet(opts), []string{"-dry-run", "-only", "a,c", "-bin-dir", filepath.Join(home, "bin"), file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := captureStderr(t, func() { err = run(args, opts) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, attempted := range map[string]bool{"a": true, "b": false, "c": true} {
+		if strings.Contains(stderr, name+": ") != attempted {
+			t.Errorf("expect %s attempted %v, but %q", name, attempted, stderr)
+		}
+	}
+}
+
+func TestLogConcurrent(t *testing.T) {
+	const goroutines, lines = 8, 50
+	for _, held := range []bool{false, true} {
+		a := &App{}
+		stderr := captureStderr(t, func() {
+			var wg sync.WaitGroup
+			for i := 0; i < goroutines; i++ {
+				p := &Package{Name: fmt.Sprintf("p%d", i)}
+				if held {
+					p.logs = &bytes.Buffer{}
+				}
+				wg.Add(1)
+				go func(p *Package) {
+					defer wg.Done()
+					for j := 0; j < lines; j++ {
+						a.Log(p, "line %d %s", j, strings.Repeat(p.Name, 500))
+					}
+					a.flushLog(p)
+				}(p)
+			}
+			wg.Wait()
+		})
+		got := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+		if len(got) != goroutines*lines {
+			t.Fatalf("held %v: expect %d lines, but %d", held, goroutines*lines, len(got))
+		}
+		next := map[string]int{}
+		for i, line := range got {
+			name := strings.SplitN(line, ":", 2)[0]
+			if expect := fmt.Sprintf("%s: line %d %s", name, next[name], strings.Repeat(name, 500)); line != expect {
+				t.Fatalf("held %v: expect a whole line of %s, but %q", held, name, line)
+			}
+			next[name]++
+			// held lines of a package are written together
+			if held && next[name] > 1 && !strings.HasPrefix(got[i-1], name+":") {
+				t.Fatalf("held %v: expect the lines of %s together", held, name)
+			}
+		}
+	}
+}
+
+func TestInstallHooks(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		io.WriteString(w, "#!/bin/sh\necho tool 1.2.0\n")
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name     string
+		pre      string
+		post     string
+		requests int
+		hooks    string
+		err      string
+	}{
+		{name: "post_install sees the installed path", post: `test -x "$DOWNLOAD_BIN" && echo "post $DOWNLOAD_NAME $DOWNLOAD_VERSION $DOWNLOAD_BIN"`,
+			requests: 1, hooks: "post tool v1.2.0 {bin}\n"},
+		{name: "pre_install runs before the download", pre: `test -e "$DOWNLOAD_BIN" || echo "pre $DOWNLOAD_BIN"`, post: `echo post`,
+			requests: 1, hooks: "pre {bin}\npost\n"},
+		{name: "pre_install fails", pre: `echo pre; exit 3`, post: `echo post`, hooks: "pre\n", err: "pre_install failed, exit status 3"},
+		{name: "post_install fails", post: `echo post; exit 4`, requests: 1, hooks: "post\n", err: "post_install failed, exit status 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			out := filepath.Join(home, "hooks.log")
+			hook := func(key, script string) string {
+				if script == "" {
+					return ""
+				}
+				return fmt.Sprintf("    %s:\n      - sh\n      - -c\n      - '{ %s; } >> %s'\n", key, script, out)
+			}
+			_, packages := loadTestPackages(t, fmt.Sprintf(`
+packages:
+  - name: tool
+    type: script
+    skip_version_check: true
+    download_url:
+      linux: %s/%%v/tool
+      mac: %s/%%v/tool
+      windows: %s/%%v/tool
+    version:
+      latest_command: [sh, -c, echo tool v1.2.0]
+      command: [sh, -c, echo tool 1.0.0]
+      format: 'tool (\S+)'
+`, srv.URL, srv.URL, srv.URL)+hook("pre_install", tt.pre)+hook("post_install", tt.post))
+			p := packages[0]
+			a := newTestAppIn(t, home)
+			mu.Lock()
+			requests = 0
+			mu.Unlock()
+			ctx := context.Background()
+			var err error
+			stderr := captureStderr(t, func() {
+				if _, err = a.Resolve(ctx, p); err == nil {
+					err = a.Install(ctx, p)
+				}
+			})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err, stderr)
+			}
+			if requests != tt.requests {
+				t.Errorf("expect %d requests, but %d", tt.requests, requests)
+			}
+			b, _ := ioutil.ReadFile(out)
+			if expect := strings.ReplaceAll(tt.hooks, "{bin}", a.TargetFile(p)); string(b) != expect {
+				t.Errorf("expect hooks %q, but %q", expect, b)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	setenv(t, "HTTPS_PROXY", "http://proxy.example.com:3128")
+	setenv(t, "HTTP_PROXY", "")
+	setenv(t, "NO_PROXY", "internal.example.com")
+	for _, name := range []string{"https_proxy", "http_proxy", "no_proxy", "REQUEST_METHOD"} {
+		setenv(t, name, "")
+	}
+	a := newTestApp(t)
+	transport, ok := a.client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expect an *http.Transport, but %T", a.client.Transport)
+	}
+	tests := []struct {
+		url   string
+		proxy string
+	}{
+		{url: "https://github.com/o/tool/releases", proxy: "http://proxy.example.com:3128"},
+		{url: "https://internal.example.com/tool", proxy: ""},
+		{url: "https://mirror.internal.example.com/tool", proxy: ""},
+		{url: "http://github.com/o/tool", proxy: ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := transport.Proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.proxy {
+			t.Errorf("expect the proxy %q for %s, but %q", tt.proxy, tt.url, got)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// manifestPackage stands for the manifest in logs and retries.
+var manifestPackage = &Package{Name: "manifest"}
+
+// fetchManifest downloads the config at u and its signature at u.sig,
+// the base64 ed25519 signature of the config made with the private key of key.
+// If sum is given, the sha256 of the config must match it as well.
+// It returns the path of the verified config in the work dir.
+func (a *App) fetchManifest(ctx context.Context, u, key, sum string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("-manifest requires -manifest-key to verify it")
+	}
+	pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("-manifest-key must be a base64 ed25519 public key")
+	}
+	body, err := a.fetchBytes(ctx, manifestPackage, u)
+	if err != nil {
+		return "", err
+	}
+	sig, err := a.fetchBytes(ctx, manifestPackage, u+".sig")
+	if err != nil {
+		return "", err
+	}
+	rawSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), body, rawSig) {
+		return "", fmt.Errorf("invalid signature of %s", u)
+	}
+	if sum != "" {
+		h := sha256.Sum256(body)
+		if got := hex.EncodeToString(h[:]); got != strings.ToLower(strings.TrimPrefix(sum, "sha256:")) {
+			return "", fmt.Errorf("checksum mismatch for %s, expect %s, but %s", u, sum, got)
+		}
+	}
+	a.Log(manifestPackage, "verified %s", u)
+	file := filepath.Join(a.workDir, "manifest.yml")
+	if err := ioutil.WriteFile(file, body, 0644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+func (a *App) fetchBytes(ctx context.Context, p *Package, u string) ([]byte, error) {
+	res, err := a.fetch(ctx, p, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil, fmt.Errorf("expect 2XX response, but %s, %s", res.Status, u)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, 10<<20))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// netrc holds the credentials of a .netrc file by machine, with "" for default.
+type netrc map[string]netrcEntry
+
+func netrcFile() string {
+	if file := os.Getenv("NETRC"); file != "" {
+		return file
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, name)
+}
+
+// loadNetrc reads $NETRC or ~/.netrc, which may not exist.
+func loadNetrc() (netrc, error) {
+	file := netrcFile()
+	if file == "" {
+		return nil, nil
+	}
+	c, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := parseNetrc(string(c))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return n, nil
+}
+
+func parseNetrc(s string) (netrc, error) {
+	n := netrc{}
+	var machine *string
+	var entry netrcEntry
+	flush := func() {
+		if machine != nil {
+			if _, ok := n[*machine]; !ok {
+				n[*machine] = entry
+			}
+		}
+		machine, entry = nil, netrcEntry{}
+	}
+	lines := strings.Split(s, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			if strings.HasPrefix(fields[j], "#") {
+				break
+			}
+			key := fields[j]
+			switch key {
+			case "default":
+				flush()
+				machine = new(string)
+				continue
+			case "macdef":
+				// a macro runs up to the next blank line
+				flush()
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+				continue
+			}
+			if j+1 >= len(fields) {
+				return nil, fmt.Errorf("line %d: %s needs a value", i+1, key)
+			}
+			j++
+			value := fields[j]
+			switch key {
+			case "machine":
+				flush()
+				machine = &value
+			case "login":
+				entry.login = value
+			case "password":
+				entry.password = value
+			case "account", "port":
+			default:
+				return nil, fmt.Errorf("line %d: unknown token %s", i+1, key)
+			}
+		}
+	}
+	flush()
+	return n, nil
+}
+
+// lookup returns the entry of host, or the default entry if useDefault.
+func (n netrc) lookup(host string, useDefault bool) (netrcEntry, bool) {
+	if e, ok := n[host]; ok {
+		return e, true
+	}
+	if !useDefault {
+		return netrcEntry{}, false
+	}
+	e, ok := n[""]
+	return e, ok
+}
+
+// netrcTransport adds basic auth from .netrc to requests that have no Authorization yet,
+// so that GITHUB_TOKEN, which is added before, takes precedence for github.com.
+// A redirect to another host, such as a presigned S3 URL, only gets the entry of that host.
+type netrcTransport struct {
+	base  http.RoundTripper
+	netrc netrc
+}
+
+func (t *netrcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	original := req
+	for original.Response != nil && original.Response.Request != nil {
+		original = original.Response.Request
+	}
+	host := req.URL.Hostname()
+	if e, ok := t.netrc.lookup(host, host == original.URL.Hostname()); ok && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(e.login, e.password)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	tests := []struct {
+		name   string
+		netrc  string
+		expect netrc
+		err    string
+	}{
+		{name: "one line", netrc: "machine example.com login me password secret\n", expect: netrc{"example.com": {"me", "secret"}}},
+		{
+			name:   "lines and default",
+			netrc:  "machine a.example.com\n  login a\n  password pa\n# comment\ndefault login d password pd\n",
+			expect: netrc{"a.example.com": {"a", "pa"}, "": {"d", "pd"}},
+		},
+		{name: "first entry wins", netrc: "machine a login 1 password 1\nmachine a login 2 password 2\n", expect: netrc{"a": {"1", "1"}}},
+		{name: "macdef is skipped", netrc: "macdef init\ncd /\nls\n\nmachine a login me password p\n", expect: netrc{"a": {"me", "p"}}},
+		{name: "missing value", netrc: "machine a login\n", err: "login needs a value"},
+		{name: "unknown token", netrc: "machine a user me\n", err: "unknown token user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseNetrc(tt.netrc)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(n, tt.expect) {
+				t.Errorf("expect %v, but %v", tt.expect, n)
+			}
+		})
+	}
+}
+
+// authServer records the basic auth user of each request by path.
+type authServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	users map[string]string
+}
+
+func newAuthServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request) bool) *authServer {
+	t.Helper()
+	s := &authServer{users: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		s.mu.Lock()
+		s.users[r.URL.Path] = user
+		s.mu.Unlock()
+		if handler == nil || !handler(w, r) {
+			w.Write([]byte("ok"))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *authServer) user(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[path]
+}
+
+func TestNetrcBasicAuth(t *testing.T) {
+	// other is reached as localhost, a host other than 127.0.0.1 of origin
+	other := newAuthServer(t, nil)
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+	origin := newAuthServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.URL.Path {
+		case "/cross":
+			http.Redirect(w, r, otherURL+"/target", http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		default:
+			return false
+		}
+		return true
+	})
+	tests := []struct {
+		name   string
+		netrc  string
+		path   string
+		origin map[string]string
+		other  string
+	}{
+		{name: "machine", netrc: "machine 127.0.0.1 login me password p\n", path: "/file", origin: map[string]string{"/file": "me"}},
+		{name: "default", netrc: "default login anyone password p\n", path: "/file", origin: map[string]string{"/file": "anyone"}},
+		{name: "other machine", netrc: "machine example.com login me password p\n", path: "/file", origin: map[string]string{"/file": ""}},
+		{
+			name:   "redirect to the same host keeps the default",
+			netrc:  "default login anyone password p\n",
+			path:   "/same",
+			origin: map[string]string{"/same": "anyone", "/target": "anyone"},
+		},
+		{
+			name:   "redirect to another host drops the default",
+			netrc:  "default login anyone password p\n",
+			path:   "/cross",
+			origin: map[string]string{"/cross": "anyone"},
+		},
+		{
+			name:   "redirect to another host drops the origin machine",
+			netrc:  "machine 127.0.0.1 login me password p\ndefault login anyone password p\n",
+			path:   "/cross",
+			origin: map[string]string{"/cross": "me"},
+		},
+		{
+			name:   "redirect to another host uses its machine",
+			netrc:  "machine 127.0.0.1 login me password p\nmachine localhost login you password p\n",
+			path:   "/cross",
+			origin: map[string]string{"/cross": "me"},
+			other:  "you",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			writeFile(t, home, ".netrc", tt.netrc)
+			a := newTestAppIn(t, home)
+			res, err := a.client.Get(origin.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			for path, user := range tt.origin {
+				if got := origin.user(path); got != user {
+					t.Errorf("expect user %q for %s, but %q", user, path, got)
+				}
+			}
+			if res.Request.URL.Hostname() == "localhost" {
+				if got := other.user("/target"); got != tt.other {
+					t.Errorf("expect user %q for the redirect target, but %q", tt.other, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+//go:build oci
+// +build oci
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+func init() {
+	fetchers["oci"] = fetchOCI
+}
+
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+type ociManifest struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// fetchOCI fetches a layer of the artifact oci://registry/repo:tag, as oras push makes.
+// If the artifact has multiple layers, ?title=name selects the one with that file name.
+// It talks to the registry API directly with anonymous bearer tokens,
+// as oras-go requires a newer Go than this module.
+func fetchOCI(ctx context.Context, client *http.Client, u *url.URL) (*http.Response, error) {
+	repo, ref := splitOCIReference(strings.TrimPrefix(u.Path, "/"))
+	if repo == "" || ref == "" {
+		return nil, fmt.Errorf("expect oci://registry/repo:tag, but %s", u)
+	}
+	base := "https://" + u.Host + "/v2/" + repo
+	c := &ociClient{client: client}
+	manifest, err := c.manifest(ctx, base, ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Manifests) > 0 {
+		// an index of per-platform manifests
+		digest := ""
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH {
+				digest = m.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return nil, fmt.Errorf("%s has no manifest for %s/%s", u, runtime.GOOS, runtime.GOARCH)
+		}
+		if manifest, err = c.manifest(ctx, base, digest); err != nil {
+			return nil, err
+		}
+	}
+	title := u.Query().Get("title")
+	var titles []string
+	for _, layer := range manifest.Layers {
+		name := layer.Annotations[ociTitleAnnotation]
+		if title != "" && name != title {
+			titles = append(titles, name)
+			continue
+		}
+		if title == "" && len(manifest.Layers) > 1 {
+			titles = append(titles, name)
+			continue
+		}
+		res, err := c.get(ctx, base+"/blobs/"+layer.Digest, "")
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			res.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		}
+		return res, nil
+	}
+	if title == "" {
+		return nil, fmt.Errorf("%s has %d layers, select one with ?title=, one of %s", u, len(manifest.Layers), strings.Join(titles, ", "))
+	}
+	return nil, fmt.Errorf("%s has no layer titled %s, but %s", u, title, strings.Join(titles, ", "))
+}
+
+func (c *ociClient) manifest(ctx context.Context, base, ref string) (*ociManifest, error) {
+	res, err := c.get(ctx, base+"/manifests/"+ref, strings.Join([]string{
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+	}, ", "))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var manifest ociManifest
+	if err := json.NewDecoder(res.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// splitOCIReference splits repo:tag or repo@digest.
+func splitOCIReference(s string) (string, string) {
+	if i := strings.Index(s, "@"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		return s[:i], s[i+1:]
+	}
+	return s, "latest"
+}
+
+type ociClient struct {
+	client *http.Client
+	token  string
+}
+
+// get GETs u, and on 401 retries once with a token from the realm of WWW-Authenticate.
+func (c *ociClient) get(ctx context.Context, u, accept string) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		res, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == http.StatusUnauthorized && !retried {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			if c.token, err = fetchOCIToken(ctx, c.client, res.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if res.StatusCode/100 != 2 {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			return nil, fmt.Errorf("expect 2XX response, but %s, %s", res.Status, u)
+		}
+		return res, nil
+	}
+}
+
+func fetchOCIToken(ctx context.Context, client *http.Client, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	params := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			params[strings.TrimSpace(kv[:i])] = strings.Trim(kv[i+1:], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry authentication %q", challenge)
+	}
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v := params[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return "", fmt.Errorf("expect 2XX response, but %s, %s", res.Status, realm)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,90 @@
+//go:build oci
+// +build oci
+
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// ociRegistry serves oci://host/o/tool:v1 with one layer, behind anonymous bearer tokens
+// whose realm needs the basic auth of .netrc.
+func ociRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, _, _ := r.BasicAuth(); user != "me" {
+				http.Error(w, "no credentials", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"t0ken"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:o/tool:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/o/tool/manifests/v1":
+			fmt.Fprintf(w, `{"manifests":[{"digest":"sha256:m","platform":{"os":%q,"architecture":%q}}]}`, runtime.GOOS, runtime.GOARCH)
+		case "/v2/o/tool/manifests/sha256:m":
+			fmt.Fprintf(w, `{"layers":[{"digest":"sha256:l","annotations":{%q:"tool"}}]}`, ociTitleAnnotation)
+		case "/v2/o/tool/blobs/sha256:l":
+			fmt.Fprint(w, "binary")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchOCIWithAppClient(t *testing.T) {
+	srv := ociRegistry(t)
+	home := isolateHome(t)
+	ca := filepath.Join(home, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(srv.URL, "https://")
+	tests := []struct {
+		name  string
+		netrc string
+		err   string
+	}{
+		{name: "CA and netrc of the app", netrc: "machine 127.0.0.1 login me password p\n"},
+		{name: "no netrc", err: "401"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFile(t, home, ".netrc", tt.netrc)
+			a := newTestAppIn(t, home, func(opts *options) { opts.caCert = ca })
+			res, err := a.fetch(context.Background(), &Package{Name: "tool"}, "oci://"+host+"/o/tool:v1", nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, _ := ioutil.ReadAll(res.Body)
+			if string(b) != "binary" || !strings.Contains(res.Header.Get("Content-Disposition"), "tool") {
+				t.Errorf("expect the layer titled tool, but %q, %v", b, res.Header)
+			}
+		})
+	}
+}
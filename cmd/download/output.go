@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+const (
+	actionInstalled    = "installed"
+	actionWouldInstall = "would_install"
+	actionOutdated     = "outdated"
+	actionSkipped      = "skipped"
+	actionFailed       = "failed"
+	actionCancelled    = "cancelled"
+)
+
+type packageResult struct {
+	Name    string `json:"name"`
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+	Action  string `json:"action"`
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"`
+}
+
+type outputSummary struct {
+	Summary map[string]int `json:"summary"`
+	Stats   *Stats         `json:"stats"`
+}
+
+// writeJSONOutput writes a packageResult per package, then an outputSummary, one per line.
+func writeJSONOutput(w io.Writer, a *App, packages []*Package, cancelled []string, reportOutdated bool) error {
+	isCancelled := map[string]bool{}
+	for _, name := range cancelled {
+		isCancelled[name] = true
+	}
+	enc := json.NewEncoder(w)
+	counts := map[string]int{}
+	for _, p := range packages {
+		r := packageResult{
+			Name:    p.Name,
+			Current: p.Version.current,
+			Latest:  p.TargetVersion(),
+			Action:  actionSkipped,
+			Path:    a.TargetFile(p),
+		}
+		switch {
+		case isCancelled[p.Name]:
+			r.Action = actionCancelled
+		case p.err != nil:
+			r.Action, r.Error = actionFailed, p.err.Error()
+		case !p.needInstall:
+		case reportOutdated:
+			r.Action = actionOutdated
+		case a.dryRun:
+			r.Action = actionWouldInstall
+		case p.locateBinaryFile != "":
+			r.Action = actionInstalled
+		}
+		counts[r.Action]++
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(outputSummary{Summary: counts, Stats: &a.stats})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1.2.0/good" {
+			io.WriteString(w, "#!/bin/sh\necho good 1.2.0\n")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	home := isolateHome(t)
+	var config strings.Builder
+	config.WriteString("packages:\n")
+	for _, c := range []struct{ name, current string }{{"good", "1.0.0"}, {"bad", "1.0.0"}, {"current", "1.2.0"}} {
+		fmt.Fprintf(&config, `
+  - name: %s
+    type: script
+    skip_version_check: true
+    download_url:
+      linux: %s/%%v/%s
+      mac: %s/%%v/%s
+      windows: %s/%%v/%s
+    version:
+      latest_command: [sh, -c, echo %s v1.2.0]
+      command: [sh, -c, echo %s %s]
+      format: '%s (\S+)'
+`, c.name, srv.URL, c.name, srv.URL, c.name, srv.URL, c.name, c.name, c.name, c.current, c.name)
+	}
+	file := writeFile(t, home, "packages.yml", config.String())
+	opts := &options{}
+	args, err := parseArgs(newFlagSet(opts), []string{"-output", "json", "-bin-dir", filepath.Join(home, "bin"), "-retries", "1", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout string
+	captureStderr(t, func() {
+		stdout = captureStdout(t, func() { err = run(args, opts) })
+	})
+	if err == nil {
+		t.Error("expect an error for the failed package")
+	}
+
+	expect := map[string]packageResult{
+		"good":    {Name: "good", Current: "1.0.0", Latest: "v1.2.0", Action: actionInstalled, Path: filepath.Join(home, "bin", "good")},
+		"bad":     {Name: "bad", Current: "1.0.0", Latest: "v1.2.0", Action: actionFailed, Path: filepath.Join(home, "bin", "bad")},
+		"current": {Name: "current", Current: "1.2.0", Latest: "v1.2.0", Action: actionSkipped, Path: filepath.Join(home, "bin", "current")},
+	}
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != len(expect)+1 {
+		t.Fatalf("expect %d lines, but %q", len(expect)+1, stdout)
+	}
+	for _, line := range lines[:len(expect)] {
+		var r packageResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%s, %q", err, line)
+		}
+		e, ok := expect[r.Name]
+		if !ok {
+			t.Fatalf("unexpected package %q", line)
+		}
+		if r.Action == actionFailed {
+			if !strings.Contains(r.Error, "404") {
+				t.Errorf("expect the 404 in the error, but %q", r.Error)
+			}
+			r.Error = ""
+		}
+		if r != e {
+			t.Errorf("expect %+v, but %+v", e, r)
+		}
+	}
+	var summary outputSummary
+	if err := json.Unmarshal([]byte(lines[len(expect)]), &summary); err != nil {
+		t.Fatalf("%s, %q", err, lines[len(expect)])
+	}
+	for action, n := range map[string]int{actionInstalled: 1, actionFailed: 1, actionSkipped: 1} {
+		if summary.Summary[action] != n {
+			t.Errorf("expect %d %s, but %v", n, action, summary.Summary)
+		}
+	}
+	if summary.Stats == nil {
+		t.Error("expect stats in the summary")
+	}
+}
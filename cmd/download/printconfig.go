@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// toGeneric converts v into maps, slices and scalars keyed by yaml tags,
+// dropping empty values so that only what is set is shown.
+func toGeneric(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	if g, ok := v.Interface().(interface{ generic() interface{} }); ok {
+		return g.generic()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return toGeneric(v.Elem())
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if f.PkgPath != "" || name == "" || name == "-" {
+				continue
+			}
+			if e := toGeneric(v.Field(i)); e != nil {
+				m[name] = e
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	case reflect.Slice:
+		var list []interface{}
+		for i := 0; i < v.Len(); i++ {
+			if e := toGeneric(v.Index(i)); e != nil {
+				list = append(list, e)
+			}
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		return list
+	case reflect.Map:
+		m := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			if e := toGeneric(v.MapIndex(k)); e != nil {
+				m[fmt.Sprint(k.Interface())] = e
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	}
+	if v.IsZero() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// yamlString is single quoted by yaml.Marshal, which otherwise leaves
+// strings such as "%v/tool" plain and unreadable.
+type yamlString string
+
+func (s yamlString) MarshalYAML() ([]byte, error) {
+	return []byte("'" + strings.ReplaceAll(string(s), "'", "''") + "'"), nil
+}
+
+// quoteStrings replaces the strings in v converted by toGeneric with yamlString,
+// except those on several lines, which yaml.Marshal writes as literal blocks.
+func quoteStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[k] = quoteStrings(e)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = quoteStrings(e)
+		}
+		return list
+	case string:
+		if !strings.Contains(v, "\n") {
+			return yamlString(v)
+		}
+	}
+	return v
+}
+
+func printConfigCommand(args []string) error {
+	fs := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download print-config [-format yaml|json] [-config-dir dir] [packages.yml...]")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "yaml", "output format, yaml or json")
+	configDir := fs.String("config-dir", "", configDirUsage)
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	if *format != "yaml" && *format != "json" {
+		fs.Usage()
+		return errReported
+	}
+	config, err := loadConfigOrDefault(fs.Args(), *configDir)
+	if err != nil {
+		return err
+	}
+	a, err := NewApp(readOnlyOptions())
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+
+	var packages []interface{}
+	for _, p := range config.Packages {
+		m, _ := toGeneric(reflect.ValueOf(p)).(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{}
+		}
+		typ := p.Type
+		if typ == "" {
+			typ = typeBinary
+		}
+		m["derived"] = map[string]interface{}{
+			"type":         typ,
+			"target":       a.TargetFile(p),
+			"supported_os": p.SupportsOS(a.os, a.arch),
+		}
+		packages = append(packages, m)
+	}
+	out, _ := toGeneric(reflect.ValueOf(config)).(map[string]interface{})
+	out["packages"] = packages
+
+	if *format == "json" {
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	b, err := yaml.Marshal(quoteStrings(out))
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(b))
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrintConfigMerged(t *testing.T) {
+	home := isolateHome(t)
+	chdir(t, home)
+	pkg := "  - name: %[1]s\n    url: https://github.com/o/%[1]s\n    download_url:\n      linux: '%%v/%[1]s_%%n_linux'\n    version:\n      fixed: %[2]s\n    post_install: [sh, -c, 'echo \"%[1]s: installed\" # done']\n"
+	base := writeFile(t, home, "base.yml", "packages:\n"+fmt.Sprintf(pkg, "a", "v1")+fmt.Sprintf(pkg, "b", "v1"))
+	local := writeFile(t, home, "local.yml", "packages:\n"+fmt.Sprintf(pkg, "a", "v2"))
+	dir := filepath.Join(home, "conf.d")
+	writeFile(t, dir, "c.yml", "packages:\n"+fmt.Sprintf(pkg, "c", "v1"))
+	writeFile(t, filepath.Join(home, ".config", "go-download"), "packages.yml", "packages:\n"+fmt.Sprintf(pkg, "d", "v1"))
+	tests := []struct {
+		name   string
+		args   []string
+		expect []string
+	}{
+		{name: "default config", args: nil, expect: []string{"d@v1"}},
+		{name: "merged files", args: []string{base, local}, expect: []string{"a@v2", "b@v1"}},
+		{name: "config dir", args: []string{"-config-dir", dir, base}, expect: []string{"a@v1", "b@v1", "c@v1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			out := captureStdout(t, func() {
+				err = printConfigCommand(append([]string{"-format", "json"}, tt.args...))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			yamlOut := captureStdout(t, func() {
+				err = printConfigCommand(tt.args)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := loadYAML(writeFile(t, tempDir(t), "printed.yml", yamlOut))
+			if err != nil {
+				t.Fatalf("%v: %s", err, yamlOut)
+			}
+			var printed []string
+			for _, p := range c.Packages {
+				printed = append(printed, p.Name+"@"+p.Version.Fixed)
+				if u := p.DownloadURL.Linux.For("amd64"); u != "%v/"+p.Name+"_%n_linux" {
+					t.Errorf("expect download_url kept, but %q", u)
+				}
+				if expect := []string{"sh", "-c", `echo "` + p.Name + `: installed" # done`}; !reflect.DeepEqual(p.PostInstall, expect) {
+					t.Errorf("expect post_install %q, but %q", expect, p.PostInstall)
+				}
+			}
+			if fmt.Sprintf("%v", printed) != fmt.Sprintf("%v", tt.expect) {
+				t.Errorf("expect %v printed as YAML, but %v", tt.expect, printed)
+			}
+
+			var config struct {
+				Packages []struct {
+					Name    string `json:"name"`
+					Version struct {
+						Fixed string `json:"fixed"`
+					} `json:"version"`
+				} `json:"packages"`
+			}
+			if err := json.Unmarshal([]byte(out), &config); err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+			var got []string
+			for _, p := range config.Packages {
+				got = append(got, p.Name+"@"+p.Version.Fixed)
+			}
+			if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", tt.expect) {
+				t.Errorf("expect %v, but %v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressBarInterval = time.Second
+	progressLogInterval = 5 * time.Second
+	progressBarWidth    = 30
+)
+
+// progressWriter counts bytes written to it, and calls report periodically
+// with a bar and percentage if bar is true and total is known,
+// or with the byte count so far otherwise.
+type progressWriter struct {
+	total    int64
+	written  int64
+	bar      bool
+	interval time.Duration
+	last     time.Time
+	report   func(string)
+}
+
+func newProgressWriter(total int64, bar bool, report func(string)) *progressWriter {
+	bar = bar && total > 0
+	interval := progressLogInterval
+	if bar {
+		interval = progressBarInterval
+	}
+	return &progressWriter{
+		total:    total,
+		bar:      bar,
+		interval: interval,
+		last:     time.Now(),
+		report:   report,
+	}
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.written += int64(len(b))
+	if now := time.Now(); now.Sub(w.last) >= w.interval {
+		w.last = now
+		w.report(w.String())
+	}
+	return len(b), nil
+}
+
+func (w *progressWriter) String() string {
+	if !w.bar {
+		if w.total > 0 {
+			return fmt.Sprintf("downloaded %d of %d bytes so far", w.written, w.total)
+		}
+		return fmt.Sprintf("downloaded %d bytes so far", w.written)
+	}
+	written := w.written
+	if written > w.total {
+		written = w.total
+	}
+	n := int(written * progressBarWidth / w.total)
+	return fmt.Sprintf("[%s%s] %3d%% %d/%d bytes",
+		strings.Repeat("=", n), strings.Repeat(" ", progressBarWidth-n),
+		written*100/w.total, w.written, w.total,
+	)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
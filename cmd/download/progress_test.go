@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProgressWithHeldLogs(t *testing.T) {
+	a := &App{}
+	tests := []struct {
+		name   string
+		held   bool
+		stderr string
+		logs   string
+	}{
+		{name: "not held", stderr: "tool: started\ntool: 50%\ntool: done\n"},
+		{name: "held", held: true, stderr: "tool: 50%\n", logs: "tool: started\ntool: done\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{Name: "tool"}
+			if tt.held {
+				p.logs = &bytes.Buffer{}
+			}
+			stderr := captureStderr(t, func() {
+				a.Log(p, "started")
+				a.Progress(p, "%d%%", 50)
+				a.Log(p, "done")
+			})
+			if stderr != tt.stderr {
+				t.Errorf("expect stderr %q, but %q", tt.stderr, stderr)
+			}
+			if tt.held && p.logs.String() != tt.logs {
+				t.Errorf("expect held %q, but %q", tt.logs, p.logs.String())
+			}
+		})
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  int64
+		bar    bool
+		writes []int
+		expect string
+	}{
+		{name: "unknown size", total: -1, writes: []int{10, 20}, expect: "downloaded 30 bytes so far"},
+		{name: "known size", total: 100, writes: []int{10, 20}, expect: "downloaded 30 of 100 bytes so far"},
+		{name: "unknown size bar", total: -1, bar: true, writes: []int{10}, expect: "downloaded 10 bytes so far"},
+		{name: "bar", total: 100, bar: true, writes: []int{50}, expect: "[===============               ]  50% 50/100 bytes"},
+		{name: "bar over total", total: 10, bar: true, writes: []int{20}, expect: "[==============================] 100% 20/10 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []string
+			w := newProgressWriter(tt.total, tt.bar, func(s string) { reports = append(reports, s) })
+			w.interval = 0
+			for _, n := range tt.writes {
+				w.Write(make([]byte, n))
+			}
+			if len(reports) != len(tt.writes) {
+				t.Fatalf("expect %d reports, but %q", len(tt.writes), reports)
+			}
+			if got := reports[len(reports)-1]; got != tt.expect {
+				t.Errorf("expect %q, but %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestProgressWriterInterval(t *testing.T) {
+	for _, bar := range []bool{false, true} {
+		var reports []string
+		w := newProgressWriter(100, bar, func(s string) { reports = append(reports, s) })
+		if expect := map[bool]time.Duration{false: progressLogInterval, true: progressBarInterval}[bar]; w.interval != expect {
+			t.Errorf("bar %v: expect interval %s, but %s", bar, expect, w.interval)
+		}
+		for i := 0; i < 10; i++ {
+			if n, err := w.Write(make([]byte, 5)); n != 5 || err != nil {
+				t.Fatalf("expect 5 bytes written, but %d, %v", n, err)
+			}
+		}
+		if len(reports) != 0 {
+			t.Errorf("bar %v: expect no reports within the interval, but %q", bar, reports)
+		}
+		if w.written != 50 {
+			t.Errorf("bar %v: expect 50 bytes counted, but %d", bar, w.written)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//go:build s3
+// +build s3
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func init() {
+	fetchers["s3"] = fetchS3
+}
+
+// fetchS3 fetches s3://bucket/key with the standard AWS credential chain.
+func fetchS3(ctx context.Context, client *http.Client, u *url.URL) (*http.Response, error) {
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{HTTPClient: client},
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		region, err := s3manager.GetBucketRegion(ctx, sess, bucket, "us-east-1")
+		if err != nil {
+			return nil, err
+		}
+		sess = sess.Copy(&aws.Config{Region: aws.String(region)})
+	}
+	out, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if out.ContentType != nil {
+		header.Set("Content-Type", *out.ContentType)
+	}
+	if out.ContentDisposition != nil {
+		header.Set("Content-Disposition", *out.ContentDisposition)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          out.Body,
+		ContentLength: aws.Int64Value(out.ContentLength),
+	}, nil
+}
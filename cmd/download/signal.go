@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleInterrupt cancels a.ctx on the first SIGINT or SIGTERM, which aborts running
+// requests and stops starting new packages, so that run can report what completed
+// and clean up; a second one exits immediately.
+func (a *App) handleInterrupt() func() {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-sig; !ok {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "interrupted, cancelling running packages (interrupt again to abort)")
+		a.interrupt()
+		if _, ok := <-sig; !ok {
+			return
+		}
+		a.Cleanup()
+		os.Exit(130)
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(sig)
+	}
+}
+
+func (a *App) isInterrupted() bool {
+	select {
+	case <-a.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
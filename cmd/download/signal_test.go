@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterruptDownload(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		w.Write([]byte(strings.Repeat("x", 1000)))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	a := newTestApp(t)
+	p := &Package{Name: "tool", downloadURL: srv.URL + "/tool"}
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Download(a.ctx, p)
+		done <- err
+	}()
+	<-started
+	a.interrupt()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expect context.Canceled, but %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the download to stop on interrupt")
+	}
+	if infos, _ := ioutil.ReadDir(filepath.Join(a.workDir, p.Name)); len(infos) > 0 {
+		t.Errorf("expect the partial file removed, but %s", infos[0].Name())
+	}
+	a.Cleanup()
+	if _, err := os.Stat(a.workDir); !os.IsNotExist(err) {
+		t.Errorf("expect the work dir removed, %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// PackageSignature is a detached PGP signature of the download.
+// URL may use the same % templates as download_url, and %f for the file name of the download.
+// PublicKey is an armored key inline, or the path or URL of one.
+type PackageSignature struct {
+	URL       string `yaml:"url"`
+	PublicKey string `yaml:"public_key"`
+}
+
+func (s *PackageSignature) build() error {
+	if s.URL == "" && s.PublicKey != "" {
+		return fmt.Errorf("signature.url is required with signature.public_key")
+	}
+	if s.URL != "" && s.PublicKey == "" {
+		return fmt.Errorf("signature.public_key is required with signature.url")
+	}
+	return nil
+}
+
+func (a *App) publicKey(ctx context.Context, p *Package) ([]byte, error) {
+	key := p.Signature.PublicKey
+	if strings.Contains(key, "-----BEGIN PGP") {
+		return []byte(key), nil
+	}
+	if strings.Contains(key, "://") {
+		return a.fetchBytes(ctx, p, key)
+	}
+	if key == "~" || strings.HasPrefix(key, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		key = home + key[1:]
+	}
+	return ioutil.ReadFile(key)
+}
+
+// VerifySignature checks file against the detached signature at signature.url,
+// made by signature.public_key.
+func (a *App) VerifySignature(ctx context.Context, p *Package, file string) error {
+	if p.Signature.URL == "" {
+		return nil
+	}
+	name := filepath.Base(file)
+	u := p.expandURL(p.resolveURL(p.Signature.URL), a.os, a.arch)
+	u = strings.ReplaceAll(u, "%f", name)
+	sig, err := a.fetchBytes(ctx, p, u)
+	if err != nil {
+		return err
+	}
+	key, err := a.publicKey(ctx, p)
+	if err != nil {
+		return err
+	}
+	id, err := verifyDetachedSignature(key, sig, file)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s with %s, %w", name, u, err)
+	}
+	a.Log(p, "verified signature by key %s", id)
+	return nil
+}
+
+var errBadSignature = errors.New("bad signature")
+
+// readKeyRing reads the binary keyring, or the armored keys concatenated in keyring.
+func readKeyRing(keyring []byte) (openpgp.EntityList, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(keyring), []byte("-----BEGIN PGP")) {
+		return openpgp.ReadKeyRing(bytes.NewReader(keyring))
+	}
+	var keys openpgp.EntityList
+	for _, block := range bytes.SplitAfter(keyring, []byte("-----END PGP PUBLIC KEY BLOCK-----")) {
+		if len(bytes.TrimSpace(block)) == 0 {
+			continue
+		}
+		el, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(block))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, el...)
+	}
+	return keys, nil
+}
+
+// verifyDetachedSignature checks that signature, armored or binary, is a valid signature
+// of file by one of the keys in keyring, and returns the ID of that key.
+func verifyDetachedSignature(keyring, signature []byte, file string) (string, error) {
+	keys, err := readKeyRing(keyring)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key, %w", err)
+	}
+	if len(bytes.TrimSpace(signature)) == 0 {
+		return "", errors.New("invalid signature, no signature packet")
+	}
+	var r io.Reader = bytes.NewReader(signature)
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP")) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return "", fmt.Errorf("invalid signature, %w", err)
+		}
+		r = block.Body
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sig, _, err := openpgp.VerifyDetachedSignature(keys, f, r, nil)
+	if err != nil {
+		var serr pgperrors.SignatureError
+		if errors.Is(err, pgperrors.ErrUnknownIssuer) || errors.As(err, &serr) || errors.Is(err, pgperrors.ErrSignatureExpired) || errors.Is(err, pgperrors.ErrKeyExpired) || errors.Is(err, pgperrors.ErrKeyRevoked) {
+			return "", fmt.Errorf("%w, %v", errBadSignature, err)
+		}
+		return "", fmt.Errorf("invalid signature, %w", err)
+	}
+	return fmt.Sprintf("%016X", *sig.IssuerKeyId), nil
+}
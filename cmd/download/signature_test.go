@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyFileSignature(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("testdata", "signature"))))
+	defer srv.Close()
+	a := newTestApp(t)
+	dir := tempDir(t)
+	data := string(readTestdata(t, "data.txt"))
+	good := writeFile(t, dir, "good/ed25519", data)
+	bad := writeFile(t, dir, "bad/ed25519", strings.ToUpper(data))
+	keyFile := filepath.Join("testdata", "signature", "ed25519.pub.asc")
+	tests := []struct {
+		name string
+		sig  PackageSignature
+		file string
+		err  string
+	}{
+		{name: "inline key", sig: PackageSignature{URL: srv.URL + "/%f.sig.asc", PublicKey: string(readTestdata(t, "ed25519.pub.asc"))}, file: good},
+		{name: "key file", sig: PackageSignature{URL: srv.URL + "/%f.sig.asc", PublicKey: keyFile}, file: good},
+		{name: "key URL", sig: PackageSignature{URL: srv.URL + "/%f.sig.asc", PublicKey: srv.URL + "/ed25519.pub.asc"}, file: good},
+		{name: "no signature", file: bad},
+		{name: "tampered", sig: PackageSignature{URL: srv.URL + "/%f.sig.asc", PublicKey: keyFile}, file: bad, err: "bad signature"},
+		{name: "other key", sig: PackageSignature{URL: srv.URL + "/%f.sig.asc", PublicKey: filepath.Join("testdata", "signature", "rsa.pub.asc")}, file: good, err: "bad signature"},
+		{name: "missing signature", sig: PackageSignature{URL: srv.URL + "/%f.missing", PublicKey: keyFile}, file: good, err: "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Package{Name: "test", Signature: tt.sig}
+			err := a.VerifyFile(context.Background(), p, tt.file)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expect error %q, but %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "signature", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestVerifyDetachedSignature(t *testing.T) {
+	data := filepath.Join("testdata", "signature", "data.txt")
+	dir := tempDir(t)
+	tampered := writeFile(t, dir, "data.txt", strings.Replace(string(readTestdata(t, "data.txt")), "hello", "HELLO", 1))
+	crlf := writeFile(t, dir, "crlf.txt", strings.Replace(string(readTestdata(t, "data.txt")), "\n", "\r\n", -1))
+	tests := []struct {
+		name string
+		key  string
+		sig  string
+		file string
+		err  string
+	}{
+		{name: "ed25519", key: "ed25519.pub.asc", sig: "ed25519.sig.asc", file: data},
+		{name: "rsa armored", key: "rsa.pub.asc", sig: "rsa.sig.asc", file: data},
+		{name: "rsa binary", key: "rsa.pub.asc", sig: "rsa.sig", file: data},
+		{name: "nistp256", key: "nistp256.pub.asc", sig: "nistp256.sig.asc", file: data},
+		{name: "signing subkey", key: "sub.pub.asc", sig: "sub.sig.asc", file: data},
+		{name: "text signature", key: "ed25519.pub.asc", sig: "ed25519-text.sig.asc", file: data},
+		{name: "text signature over CRLF", key: "ed25519.pub.asc", sig: "ed25519-text.sig.asc", file: crlf},
+		{name: "keyring of several keys", key: "rsa.pub.asc+ed25519.pub.asc", sig: "ed25519.sig.asc", file: data},
+		{name: "tampered file", key: "ed25519.pub.asc", sig: "ed25519.sig.asc", file: tampered, err: "bad signature"},
+		{name: "tampered file rsa", key: "rsa.pub.asc", sig: "rsa.sig", file: tampered, err: "bad signature"},
+		{name: "binary signature over CRLF", key: "ed25519.pub.asc", sig: "ed25519.sig.asc", file: crlf, err: "bad signature"},
+		{name: "other key", key: "rsa.pub.asc", sig: "ed25519.sig.asc", file: data, err: "bad signature"},
+		{name: "not a signature", key: "ed25519.pub.asc", sig: "ed25519.pub.asc", file: data, err: "non signature packet"},
+		{name: "not a key", key: "ed25519.sig.asc", sig: "ed25519.sig.asc", file: data, err: "invalid public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key []byte
+			for _, name := range strings.Split(tt.key, "+") {
+				key = append(key, readTestdata(t, name)...)
+			}
+			id, err := verifyDetachedSignature(key, readTestdata(t, tt.sig), tt.file)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(id) != 16 {
+					t.Errorf("expect a 16 digit key ID, but %q", id)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expect error %q, but %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyDetachedSignatureCorrupted(t *testing.T) {
+	data := filepath.Join("testdata", "signature", "data.txt")
+	key := readTestdata(t, "rsa.pub.asc")
+	sig := readTestdata(t, "rsa.sig")
+	flipped := append([]byte(nil), sig...)
+	flipped[len(flipped)-1] ^= 0xff
+	armored := readTestdata(t, "rsa.sig.asc")
+	lines := bytes.Split(armored, []byte("\n"))
+	// replace the first character of the base64 body
+	for i, line := range lines {
+		if i > 0 && len(lines[i-1]) == 0 && len(line) > 0 {
+			if line[0] == 'A' {
+				line[0] = 'B'
+			} else {
+				line[0] = 'A'
+			}
+			break
+		}
+	}
+	tests := []struct {
+		name string
+		sig  []byte
+		err  string
+	}{
+		{name: "flipped signature byte", sig: flipped, err: "bad signature"},
+		{name: "truncated", sig: sig[:len(sig)/2], err: "unexpected EOF"},
+		{name: "armor checksum", sig: bytes.Join(lines, []byte("\n")), err: "invalid signature"},
+		{name: "empty", sig: nil, err: "no signature packet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyDetachedSignature(key, tt.sig, data)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expect error %q, but %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// State is persisted across runs.
+type State struct {
+	LastModified map[string]string `json:"last_modified"`
+
+	mu   sync.Mutex
+	file string
+}
+
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "go-download"), nil
+}
+
+func loadState() (*State, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	s := &State{file: filepath.Join(dir, "state.json")}
+	c, err := ioutil.ReadFile(s.file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(c, s); err != nil {
+			return nil, err
+		}
+	}
+	if s.LastModified == nil {
+		s.LastModified = map[string]string{}
+	}
+	return s, nil
+}
+
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, append(b, '\n'), 0644)
+}
+
+func (s *State) lastModified(u string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.LastModified[u]
+}
+
+func (s *State) setLastModified(u, lastModified string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lastModified == "" {
+		delete(s.LastModified, u)
+	} else {
+		s.LastModified[u] = lastModified
+	}
+}
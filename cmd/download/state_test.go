@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUserDirs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("HOME is not the home directory")
+	}
+	tests := []struct {
+		name  string
+		home  string
+		xdg   string
+		state string
+		cache string
+	}{
+		{name: "HOME", home: "/home/me", state: "/home/me/.local/state/go-download"},
+		{name: "XDG", home: "/home/me", xdg: "/xdg", state: "/xdg/state/go-download", cache: "/xdg/cache/go-download"},
+		{name: "XDG without HOME", xdg: "/xdg", state: "/xdg/state/go-download", cache: "/xdg/cache/go-download"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HOME", tt.home)
+			setenv(t, "XDG_STATE_HOME", "")
+			setenv(t, "XDG_CACHE_HOME", "")
+			if tt.xdg != "" {
+				setenv(t, "XDG_STATE_HOME", filepath.Join(tt.xdg, "state"))
+				setenv(t, "XDG_CACHE_HOME", filepath.Join(tt.xdg, "cache"))
+			}
+			state, err := stateDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != tt.state {
+				t.Errorf("stateDir: expect %s, but %s", tt.state, state)
+			}
+			cache, err := cacheDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// os.UserCacheDir differs by OS without XDG_CACHE_HOME
+			if tt.cache != "" && cache != tt.cache {
+				t.Errorf("cacheDir: expect %s, but %s", tt.cache, cache)
+			}
+		})
+	}
+}
+
+func TestLockWithoutHOME(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("HOME is not the home directory")
+	}
+	dir := tempDir(t)
+	setenv(t, "HOME", "")
+	setenv(t, "XDG_STATE_HOME", dir)
+	unlock, err := lock(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if _, err := lock(false); err != errLocked {
+		t.Fatalf("expect %v, but %v", errLocked, err)
+	}
+}
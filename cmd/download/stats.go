@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is accumulated by concurrent workers, so update it with atomic operations only.
+type Stats struct {
+	DownloadedBytes int64         `json:"downloaded_bytes"`
+	DownloadTime    time.Duration `json:"download_time_ns"`
+	ExtractTime     time.Duration `json:"extract_time_ns"`
+	CacheHits       int64         `json:"cache_hits"`
+	APIRequests     int64         `json:"api_requests"`
+}
+
+func (s *Stats) addDownload(bytes int64, d time.Duration) {
+	atomic.AddInt64(&s.DownloadedBytes, bytes)
+	atomic.AddInt64((*int64)(&s.DownloadTime), int64(d))
+}
+
+func (s *Stats) addExtract(d time.Duration) {
+	atomic.AddInt64((*int64)(&s.ExtractTime), int64(d))
+}
+
+func (s *Stats) addCacheHit() {
+	atomic.AddInt64(&s.CacheHits, 1)
+}
+
+func (s *Stats) addAPIRequest() {
+	atomic.AddInt64(&s.APIRequests, 1)
+}
+
+func (s *Stats) String() string {
+	return fmt.Sprintf("downloaded %d bytes in %s, extracted in %s, %d cache hits, %d API requests",
+		atomic.LoadInt64(&s.DownloadedBytes),
+		time.Duration(atomic.LoadInt64((*int64)(&s.DownloadTime))).Round(time.Millisecond),
+		time.Duration(atomic.LoadInt64((*int64)(&s.ExtractTime))).Round(time.Millisecond),
+		atomic.LoadInt64(&s.CacheHits),
+		atomic.LoadInt64(&s.APIRequests),
+	)
+}
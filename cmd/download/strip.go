@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Strip runs strip on the binary of p to be installed if post_install_strip is set.
+// A missing strip or a binary strip cannot handle is only logged.
+func (a *App) Strip(p *Package) {
+	if !p.PostInstallStrip || p.IsScript() {
+		return
+	}
+	file := p.downloadBinaryFile
+	if _, err := exec.LookPath("strip"); err != nil {
+		a.Log(p, "skip stripping, strip is not found")
+		return
+	}
+	before, err := os.Stat(file)
+	if err != nil {
+		a.Log(p, "skip stripping, %s", err)
+		return
+	}
+	out, err := exec.Command("strip", file).CombinedOutput()
+	if err != nil {
+		a.Log(p, "skip stripping, %s", strings.TrimSpace(string(out)))
+		return
+	}
+	after, err := os.Stat(file)
+	if err != nil {
+		a.Log(p, "skip stripping, %s", err)
+		return
+	}
+	a.Log(p, "stripped %d bytes", before.Size()-after.Size())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Uninstall removes the installed binary of p,
+// and reports whether there was one to remove.
+func (a *App) Uninstall(p *Package) (bool, error) {
+	dir := a.BinDir(p)
+	target := a.TargetFile(p)
+	if filepath.Dir(target) != filepath.Clean(dir) {
+		return false, fmt.Errorf("refuse to remove %s, it is not in %s", target, dir)
+	}
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return false, fmt.Errorf("refuse to remove %s, it is a directory", target)
+	}
+	if err := os.Remove(target); err != nil {
+		return false, err
+	}
+	if err := os.RemoveAll(a.versionsDir(p)); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+func uninstallCommand(args []string) error {
+	opts := defaultOptions()
+	fs := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: download uninstall [-bin-dir dir] [-config-dir dir] name [packages.yml...]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.binDir, "bin-dir", "", "directory binaries are installed to, default $HOME/bin")
+	fs.StringVar(&opts.configDir, "config-dir", "", configDirUsage)
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errReported
+	}
+	config, err := loadConfigOrDefault(fs.Args()[1:], opts.configDir)
+	if err != nil {
+		return err
+	}
+	p, err := findPackage(config.Packages, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	a, err := NewApp(opts)
+	if err != nil {
+		return err
+	}
+	defer a.Cleanup()
+	removed, err := a.Uninstall(p)
+	if err != nil {
+		return err
+	}
+	if removed {
+		a.Log(p, "removed %s", a.TargetFile(p))
+	} else {
+		a.Log(p, "%s is already absent", a.TargetFile(p))
+	}
+	return nil
+}
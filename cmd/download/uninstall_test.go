@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const uninstallTestConfig = `
+packages:
+  - name: tool
+    download_url:
+      linux: https://example.com/tool-linux
+      mac: https://example.com/tool-mac
+      windows: https://example.com/tool.exe
+    version:
+      fixed: v1.0.0
+  - name: golang-migrate
+    install_as: migrate
+    download_url:
+      linux: https://example.com/migrate-linux
+      mac: https://example.com/migrate-mac
+      windows: https://example.com/migrate.exe
+    version:
+      fixed: v1.0.0
+`
+
+func TestUninstallCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []string
+		dirs      []string
+		uninstall string
+		removed   string
+		log       string
+		err       string
+	}{
+		{name: "present", installed: []string{"tool", "other"}, uninstall: "tool", removed: "tool", log: "tool: removed "},
+		{name: "absent", installed: []string{"other"}, uninstall: "tool", log: "tool: "},
+		{name: "install_as", installed: []string{"migrate", "golang-migrate"}, uninstall: "golang-migrate", removed: "migrate", log: "golang-migrate: removed "},
+		{name: "unknown package", installed: []string{"other"}, uninstall: "other", err: "unknown package other"},
+		{name: "directory", dirs: []string{"tool"}, uninstall: "tool", err: "it is a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolateHome(t)
+			binDir := filepath.Join(home, "bin")
+			exe := ""
+			if runtime.GOOS == "windows" {
+				exe = ".exe"
+			}
+			for _, name := range tt.installed {
+				writeFile(t, binDir, name+exe, "binary")
+			}
+			for _, name := range tt.dirs {
+				if err := os.MkdirAll(filepath.Join(binDir, name+exe), 0777); err != nil {
+					t.Fatal(err)
+				}
+			}
+			config := writeFile(t, home, "packages.yml", uninstallTestConfig)
+			var err error
+			stderr := captureStderr(t, func() {
+				err = uninstallCommand([]string{"-bin-dir", binDir, tt.uninstall, config})
+			})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expect error %q, but %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(stderr, tt.log) {
+				t.Errorf("expect log %q, but %q", tt.log, stderr)
+			}
+			if tt.removed == "" && tt.err == "" && !strings.Contains(stderr, "is already absent") {
+				t.Errorf("expect already absent, but %q", stderr)
+			}
+			for _, name := range tt.installed {
+				_, err := os.Stat(filepath.Join(binDir, name+exe))
+				if removed := os.IsNotExist(err); removed != (name == tt.removed) {
+					t.Errorf("expect %s removed %v, but %v", name, name == tt.removed, removed)
+				}
+			}
+			for _, name := range tt.dirs {
+				if _, err := os.Stat(filepath.Join(binDir, name+exe)); err != nil {
+					t.Errorf("expect %s kept, %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// configError lists every invalid package of a config file.
+type configError struct {
+	file string
+	errs []error
+}
+
+func (e *configError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is invalid:", e.file)
+	for _, err := range e.errs {
+		b.WriteString("\n  " + err.Error())
+	}
+	return b.String()
+}
+
+// Validate checks that the fields p needs are present, before Build compiles them.
+// It returns a problem per field, prefixed by the name of p.
+func (p *Package) Validate() []error {
+	name := p.Name
+	if name == "" {
+		name = "(no name)"
+	}
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(name+": "+format, args...))
+	}
+	if p.Name == "" {
+		add("name is required")
+	}
+	relative, found, empty := false, false, false
+	for _, d := range []struct {
+		key string
+		url ArchURL
+	}{{"mac", p.DownloadURL.Mac}, {"linux", p.DownloadURL.Linux}, {"windows", p.DownloadURL.Windows}} {
+		var archs []string
+		for arch, u := range d.url.values() {
+			if u == "" {
+				archs = append(archs, arch)
+			}
+			found = found || u != ""
+			relative = relative || (u != "" && !strings.Contains(u, "://"))
+		}
+		sort.Strings(archs)
+		for _, arch := range archs {
+			add("download_url.%s.%s is empty", d.key, arch)
+			empty = true
+		}
+	}
+	for _, d := range []ArchURL{p.AssetPattern.Mac, p.AssetPattern.Linux, p.AssetPattern.Windows} {
+		found = found || len(d.values()) > 0
+	}
+	if !found && !empty {
+		add("download_url or asset_pattern is required")
+	}
+	v := &p.Version
+	lookup := v.Fixed == "" && len(v.LatestCommand) == 0 && !p.IsScript() &&
+		v.Source != sourceJSON && v.Source != sourceHTML
+	if p.URL == "" {
+		if relative {
+			add("url is required for relative download_url")
+		} else if lookup {
+			add("url is required to look up the latest version, or set version.fixed")
+		}
+	}
+	if len(v.Command) > 0 && v.Command[0] == "" {
+		add("version.command must not start with an empty string")
+	}
+	if len(p.PreInstall) > 0 && p.PreInstall[0] == "" {
+		add("pre_install must not start with an empty string")
+	}
+	if len(p.PostInstall) > 0 && p.PostInstall[0] == "" {
+		add("post_install must not start with an empty string")
+	}
+	return errs
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errs   []string
+	}{
+		{name: "valid", config: `
+  - name: tool
+    url: https://github.com/o/tool
+    download_url: {linux: "%v/tool"}
+    version: {command: [tool, --version], format: 'tool (\S+)'}
+`},
+		{name: "no name", config: `
+  - url: https://github.com/o/tool
+    download_url: {linux: "%v/tool"}
+`, errs: []string{"(no name): name is required"}},
+		{name: "no download_url", config: `
+  - name: tool
+    url: https://github.com/o/tool
+`, errs: []string{"tool: download_url or asset_pattern is required"}},
+		{name: "empty download_url", config: `
+  - name: tool
+    url: https://github.com/o/tool
+    download_url: {windows: {amd64: ""}, linux: {amd64: "%v/tool", arm64: ""}, mac: {arm64: ""}}
+`, errs: []string{"tool: download_url.mac.arm64 is empty", "tool: download_url.linux.arm64 is empty", "tool: download_url.windows.amd64 is empty"}},
+		{name: "relative without url", config: `
+  - name: tool
+    download_url: {linux: "%v/tool"}
+    version: {fixed: v1.0.0}
+`, errs: []string{"tool: url is required for relative download_url"}},
+		{name: "latest without url", config: `
+  - name: tool
+    download_url: {linux: "https://example.com/tool"}
+`, errs: []string{"tool: url is required to look up the latest version, or set version.fixed"}},
+		{name: "command without format skips the version check", config: `
+  - name: tool
+    url: https://github.com/o/tool
+    download_url: {linux: "%v/tool"}
+    version: {command: [tool, --version]}
+`},
+		{name: "format for latest_command alone", config: `
+  - name: tool
+    download_url: {linux: "https://example.com/%v/tool"}
+    version: {latest_command: [sh, -c, echo tool v1.0.0], format: 'tool (\S+)'}
+`},
+		{name: "empty commands", config: `
+  - name: tool
+    url: https://github.com/o/tool
+    download_url: {linux: "%v/tool"}
+    version: {command: ["", --version], format: 'tool (\S+)'}
+    pre_install: [""]
+    post_install: ["", x]
+`, errs: []string{
+			"tool: version.command must not start with an empty string",
+			"tool: pre_install must not start with an empty string",
+			"tool: post_install must not start with an empty string",
+		}},
+		{name: "every package", config: `
+  - name: a
+    url: https://github.com/o/a
+  - name: ok
+    url: https://github.com/o/ok
+    download_url: {linux: "%v/ok"}
+  - name: b
+    download_url: {linux: "%v/b"}
+    version: {fixed: v1.0.0}
+`, errs: []string{
+			"a: download_url or asset_pattern is required",
+			"b: url is required for relative download_url",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeFile(t, tempDir(t), "packages.yml", "packages:"+tt.config)
+			_, err := loadYAML(file)
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var cerr *configError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("expect a configError, but %v", err)
+			}
+			if cerr.file != file {
+				t.Errorf("expect the file %s, but %s", file, cerr.file)
+			}
+			var errs []string
+			for _, err := range cerr.errs {
+				errs = append(errs, err.Error())
+			}
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("expect %q, but %q", tt.errs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateCommandWithoutFormat(t *testing.T) {
+	_, packages := loadTestPackages(t, `
+packages:
+  - name: tool
+    url: https://github.com/o/tool
+    download_url: {linux: "%v/tool"}
+    version: {command: [sh, -c, echo tool 1.0.0]}
+`)
+	a := newTestApp(t)
+	if _, err := a.CurrentVersion(a.ctx, packages[0]); err != errSkip {
+		t.Errorf("expect the version check skipped, but %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// splitVersion splits "v1.2.3-rc1" into [1 2 3] and "rc1".
+func splitVersion(v string) ([]int, string) {
+	v = strings.TrimPrefix(v, "v")
+	pre := ""
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	var nums []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+	}
+	return nums, pre
+}
+
+// compareVersions compares versions such as v1.2.3 numerically,
+// where a version with a prerelease suffix is older than the one without it.
+func compareVersions(a, b string) int {
+	an, apre := splitVersion(a)
+	bn, bpre := splitVersion(b)
+	for i := 0; i < len(an) || i < len(bn); i++ {
+		x, y := 0, 0
+		if i < len(an) {
+			x = an[i]
+		}
+		if i < len(bn) {
+			y = bn[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	case apre < bpre:
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// versionsDir is where keep_latest_n keeps the installed versions of p.
+func (a *App) versionsDir(p *Package) string {
+	return filepath.Join(a.BinDir(p), ".versions", p.CommandName())
+}
+
+// KeepVersion copies the binary just installed into versionsDir,
+// and removes the versions there beyond the newest keep_latest_n.
+func (a *App) KeepVersion(p *Package) error {
+	v := p.TargetVersion()
+	if p.KeepLatestN == 0 || v == "" || v != filepath.Base(v) || v == "." || v == ".." {
+		return nil
+	}
+	dir := a.versionsDir(p)
+	if err := os.MkdirAll(filepath.Join(dir, v), 0777); err != nil {
+		return err
+	}
+	if err := copyFile(p.locateBinaryFile, filepath.Join(dir, v, filepath.Base(p.locateBinaryFile))); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var versions []string
+	for _, info := range infos {
+		if info.IsDir() {
+			versions = append(versions, info.Name())
+		}
+	}
+	if len(versions) <= p.KeepLatestN {
+		return nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) > 0 })
+	for _, old := range versions[p.KeepLatestN:] {
+		if err := os.RemoveAll(filepath.Join(dir, old)); err != nil {
+			return err
+		}
+		a.Log(p, "removed old version %s", old)
+	}
+	return nil
+}
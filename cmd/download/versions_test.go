@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeepLatestN(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "#!/bin/sh\necho tool "+strings.Split(r.URL.Path, "/")[1]+"\n")
+	}))
+	defer srv.Close()
+	home := isolateHome(t)
+	tests := []struct {
+		version string
+		kept    []string
+		removed string
+	}{
+		{version: "v1.2.0", kept: []string{"v1.2.0"}},
+		{version: "v1.10.0", kept: []string{"v1.10.0", "v1.2.0"}},
+		{version: "v1.9.0", kept: []string{"v1.10.0", "v1.9.0"}, removed: "v1.2.0"},
+		{version: "v1.11.0", kept: []string{"v1.10.0", "v1.11.0"}, removed: "v1.9.0"},
+	}
+	for _, tt := range tests {
+		_, packages := loadTestPackages(t, fmt.Sprintf(`
+packages:
+  - name: tool
+    type: script
+    keep_latest_n: 2
+    download_url:
+      linux: %s/%%v/tool
+      mac: %s/%%v/tool
+      windows: %s/%%v/tool
+    version:
+      fixed: %s
+`, srv.URL, srv.URL, srv.URL, tt.version))
+		p := packages[0]
+		a := newTestAppIn(t, home)
+		ctx := context.Background()
+		var err error
+		stderr := captureStderr(t, func() {
+			if _, err = a.Resolve(ctx, p); err == nil {
+				err = a.Install(ctx, p)
+			}
+		})
+		if err != nil {
+			t.Fatal(err, stderr)
+		}
+		infos, err := ioutil.ReadDir(a.versionsDir(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept []string
+		for _, info := range infos {
+			kept = append(kept, info.Name())
+		}
+		if !reflect.DeepEqual(kept, tt.kept) {
+			t.Errorf("expect %v kept after installing %s, but %v", tt.kept, tt.version, kept)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(a.versionsDir(p), tt.version, "tool")); err != nil || string(b) != "#!/bin/sh\necho tool "+tt.version+"\n" {
+			t.Errorf("expect a copy of %s, but %q, %v", tt.version, b, err)
+		}
+		if log := "tool: removed old version " + tt.removed + "\n"; tt.removed != "" && !strings.Contains(stderr, log) {
+			t.Errorf("expect %q in\n%s", log, stderr)
+		} else if tt.removed == "" && strings.Contains(stderr, "removed old version") {
+			t.Errorf("expect nothing removed, but\n%s", stderr)
+		}
+	}
+	_, packages := loadTestPackages(t, "packages:\n  - name: tool\n    download_url: {linux: https://example.com/tool}\n    version: {fixed: v1.0.0}\n")
+	a := newTestAppIn(t, home)
+	if _, err := a.Uninstall(packages[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a.versionsDir(packages[0])); !os.IsNotExist(err) {
+		t.Errorf("expect the kept versions removed by uninstall, %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// whenRule is a condition of when, one of key, key=pattern and key!=pattern,
+// where key is os, arch, hostname or env.NAME, and pattern is a glob.
+// A bare key holds if its value is not empty.
+type whenRule struct {
+	key     string
+	op      string
+	pattern string
+}
+
+func parseWhenRule(s string) (*whenRule, error) {
+	r := &whenRule{key: s}
+	for _, op := range []string{"!=", "="} {
+		if i := strings.Index(s, op); i >= 0 {
+			r = &whenRule{key: s[:i], op: op, pattern: s[i+len(op):]}
+			break
+		}
+	}
+	r.key = strings.TrimSpace(r.key)
+	r.pattern = strings.TrimSpace(r.pattern)
+	switch {
+	case r.key == "os", r.key == "arch", r.key == "hostname":
+	case strings.HasPrefix(r.key, "env.") && len(r.key) > len("env."):
+	default:
+		return nil, fmt.Errorf("unknown key %q in when %q, expect os, arch, hostname or env.NAME", r.key, s)
+	}
+	if _, err := path.Match(r.pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern in when %q, %w", s, err)
+	}
+	return r, nil
+}
+
+func (a *App) whenValue(key string) string {
+	switch key {
+	case "os":
+		return a.os
+	case "arch":
+		return a.arch
+	case "hostname":
+		name, _ := os.Hostname()
+		return name
+	}
+	return os.Getenv(strings.TrimPrefix(key, "env."))
+}
+
+func (a *App) holds(r *whenRule) bool {
+	v := a.whenValue(r.key)
+	if r.op == "" {
+		return v != ""
+	}
+	matched, _ := path.Match(r.pattern, v)
+	return matched == (r.op == "=")
+}
+
+// unmetCondition returns the first condition of when that does not hold, or "".
+func (a *App) unmetCondition(p *Package) string {
+	for i, r := range p.whenRules {
+		if !a.holds(r) {
+			return p.When[i]
+		}
+	}
+	return ""
+}
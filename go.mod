@@ -3,6 +3,9 @@ module github.com/skaji/go-download
 go 1.14
 
 require (
+	github.com/ProtonMail/go-crypto v1.0.0
+	github.com/aws/aws-sdk-go v1.34.0
 	github.com/goccy/go-yaml v1.4.0
 	github.com/mholt/archiver/v3 v3.3.0
+	golang.org/x/net v0.8.0
 )